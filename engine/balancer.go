@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	leastRequestBalancer = "gapi_least_request"
+	weightedBalancer     = "gapi_weighted"
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(leastRequestBalancer, &leastRequestPickerBuilder{}, base.Config{HealthCheck: true}))
+	balancer.Register(base.NewBalancerBuilder(weightedBalancer, &weightedPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type weightKey struct{}
+
+func withWeight(addr resolver.Address, weight uint32) resolver.Address {
+	addr.Attributes = addr.Attributes.WithValue(weightKey{}, weight)
+	return addr
+}
+
+func addressWeight(addr resolver.Address) uint32 {
+	w, _ := addr.Attributes.Value(weightKey{}).(uint32)
+	if w == 0 {
+		return 1
+	}
+	return w
+}
+
+type leastRequestSubConn struct {
+	sc       balancer.SubConn
+	inflight atomic.Int64
+}
+
+type leastRequestPickerBuilder struct{}
+
+func (*leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	scs := make([]*leastRequestSubConn, 0, len(info.ReadySCs))
+	for sc := range info.ReadySCs {
+		scs = append(scs, &leastRequestSubConn{sc: sc})
+	}
+	return &leastRequestPicker{scs: scs}
+}
+
+// leastRequestPicker 选择进行中请求最少的 SubConn，数量相同时轮流选择
+type leastRequestPicker struct {
+	scs  []*leastRequestSubConn
+	next atomic.Uint32
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	n := len(p.scs)
+	start := int(p.next.Add(1) % uint32(n))
+	best := p.scs[start]
+	for i := 1; i < n; i++ {
+		sc := p.scs[(start+i)%n]
+		if sc.inflight.Load() < best.inflight.Load() {
+			best = sc
+		}
+	}
+	best.inflight.Add(1)
+	return balancer.PickResult{
+		SubConn: best.sc,
+		Done: func(balancer.DoneInfo) {
+			best.inflight.Add(-1)
+		},
+	}, nil
+}
+
+type weightedSubConn struct {
+	sc      balancer.SubConn
+	weight  int64
+	current int64
+}
+
+type weightedPickerBuilder struct{}
+
+func (*weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+	p := &weightedPicker{}
+	for sc, sci := range info.ReadySCs {
+		w := int64(addressWeight(sci.Address))
+		p.scs = append(p.scs, &weightedSubConn{sc: sc, weight: w})
+		p.total += w
+	}
+	return p
+}
+
+// weightedPicker 使用平滑加权轮询，避免连续选中同一个高权重的 SubConn
+type weightedPicker struct {
+	mu    sync.Mutex
+	scs   []*weightedSubConn
+	total int64
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	var best *weightedSubConn
+	for _, sc := range p.scs {
+		sc.current += sc.weight
+		if best == nil || sc.current > best.current {
+			best = sc
+		}
+	}
+	best.current -= p.total
+	p.mu.Unlock()
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
@@ -0,0 +1,294 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vizee/gapi/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type BreakerState uint32
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+type BreakerConfig struct {
+	// ConsecutiveFailures 是触发熔断的连续失败次数，0 表示不按连续失败熔断
+	ConsecutiveFailures int
+	// FailureRate 是 Window 内触发熔断的失败率，0 表示不按失败率熔断
+	FailureRate float64
+	// MinRequests 是按失败率熔断时 Window 内至少需要的请求数，默认 10
+	MinRequests int
+	// Window 是统计失败率的时间窗口，默认 10s
+	Window time.Duration
+	// OpenTimeout 是熔断后进入半开状态前的等待时间，默认 5s
+	OpenTimeout time.Duration
+	// HalfOpenRequests 是半开状态下允许同时进行的探测请求数，默认 1
+	HalfOpenRequests int
+	// Codes 是计为失败的状态码，默认为 Unavailable、DeadlineExceeded、Internal 和 Unknown。
+	// 客户端指定的超时用完或者客户端断开造成的错误总是不计入
+	Codes []codes.Code
+}
+
+var defaultBreakerCodes = []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown}
+
+type BreakerStats struct {
+	State               BreakerState
+	ConsecutiveFailures int
+	Requests            int
+	Failures            int
+	OpenedAt            time.Time
+}
+
+type breaker struct {
+	server string
+	cfg    *BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+}
+
+// admission 记录一次被熔断器放行的调用，调用结束后需要调用 done
+type admission struct {
+	b     *breaker
+	probe bool
+	// since 是放行探测请求时半开状态开始的时间
+	since time.Time
+}
+
+func (a admission) done(err error) {
+	if a.b != nil {
+		a.b.done(a.probe, a.b.failed(err))
+	}
+}
+
+// cancel 用于没有发出调用或者结果与 server 无关的请求，例如在调用前失败，不计入统计，只归还探测名额
+func (a admission) cancel() {
+	if a.b != nil && a.probe {
+		a.b.cancelProbe(a.since)
+	}
+}
+
+// clientFault 判断错误是否由客户端造成：客户端指定的期限用完或者客户端断开，这类错误与 server 无关
+func clientFault(reqctx context.Context, clientDeadline bool, err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded:
+		return clientDeadline
+	case codes.Canceled:
+		return reqctx.Err() != nil
+	}
+	return false
+}
+
+func (b *breaker) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	// 只统计 GRPC 调用返回的错误，读写 HTTP 请求的错误与 server 无关
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	for _, c := range b.cfg.Codes {
+		if c == st.Code() {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *breaker) setState(state BreakerState, now time.Time) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	b.consecutive, b.requests, b.failures, b.probes = 0, 0, 0, 0
+	b.windowStart = now
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+	log.Warnf("Circuit breaker of %s: %s", b.server, state)
+}
+
+func (b *breaker) allow() (admission, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == BreakerOpen {
+		if now.Sub(b.openedAt) < b.cfg.OpenTimeout {
+			return admission{}, false
+		}
+		b.setState(BreakerHalfOpen, now)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.cfg.HalfOpenRequests {
+			return admission{}, false
+		}
+		b.probes++
+		return admission{b: b, probe: true, since: b.windowStart}, true
+	}
+	return admission{b: b}, true
+}
+
+func (b *breaker) done(probe bool, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if probe {
+		if b.state != BreakerHalfOpen {
+			return
+		}
+		if failed {
+			b.setState(BreakerOpen, now)
+		} else {
+			b.setState(BreakerClosed, now)
+		}
+		return
+	}
+	// 熔断前放行的请求在状态改变后结束，不再统计
+	if b.state != BreakerClosed {
+		return
+	}
+	if now.Sub(b.windowStart) > b.cfg.Window {
+		b.windowStart = now
+		b.requests, b.failures = 0, 0
+	}
+	b.requests++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	b.failures++
+	b.consecutive++
+	if (b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures) ||
+		(b.cfg.FailureRate > 0 && b.requests >= b.cfg.MinRequests && float64(b.failures) >= b.cfg.FailureRate*float64(b.requests)) {
+		b.setState(BreakerOpen, now)
+	}
+}
+
+func (b *breaker) cancelProbe(since time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// 状态已经改变时名额已经重置
+	if b.state == BreakerHalfOpen && b.windowStart.Equal(since) && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *breaker) stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.consecutive,
+		Requests:            b.requests,
+		Failures:            b.failures,
+		OpenedAt:            b.openedAt,
+	}
+}
+
+// breakerSet 保存每个 server 的熔断器，server 在 router 重建后仍然存在时保留原来的状态
+type breakerSet struct {
+	cfg     BreakerConfig
+	mu      sync.Mutex
+	servers atomic.Pointer[map[string]*breaker]
+}
+
+func newBreakerSet(cfg BreakerConfig) *breakerSet {
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 10
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 5 * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	if len(cfg.Codes) == 0 {
+		cfg.Codes = defaultBreakerCodes
+	}
+	return &breakerSet{cfg: cfg}
+}
+
+func (s *breakerSet) sync(clients map[string]*connPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var old map[string]*breaker
+	if p := s.servers.Load(); p != nil {
+		old = *p
+	}
+	servers := make(map[string]*breaker, len(clients))
+	now := time.Now()
+	for server := range clients {
+		b := old[server]
+		if b == nil {
+			b = &breaker{server: server, cfg: &s.cfg, windowStart: now}
+		}
+		servers[server] = b
+	}
+	s.servers.Store(&servers)
+}
+
+func (s *breakerSet) get(server string) *breaker {
+	p := s.servers.Load()
+	if p == nil {
+		return nil
+	}
+	return (*p)[server]
+}
+
+func (e *Engine) admit(server string) (admission, bool) {
+	if e.breakers == nil {
+		return admission{}, true
+	}
+	b := e.breakers.get(server)
+	if b == nil {
+		return admission{}, true
+	}
+	return b.allow()
+}
+
+// Breakers 返回每个 server 的熔断器状态，未开启熔断时返回 nil
+func (e *Engine) Breakers() map[string]BreakerStats {
+	if e.breakers == nil {
+		return nil
+	}
+	p := e.breakers.servers.Load()
+	if p == nil {
+		return nil
+	}
+	stats := make(map[string]BreakerStats, len(*p))
+	for server, b := range *p {
+		stats[server] = b.stats()
+	}
+	return stats
+}
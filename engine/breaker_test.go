@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestBreaker(cfg BreakerConfig) *breaker {
+	s := newBreakerSet(cfg)
+	return &breaker{server: "mock", cfg: &s.cfg, windowStart: time.Now()}
+}
+
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	b := newTestBreaker(BreakerConfig{ConsecutiveFailures: 2, OpenTimeout: 20 * time.Millisecond})
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	for _, err := range []error{unavailable, nil, unavailable, status.Error(codes.InvalidArgument, ""), errors.New("write failed")} {
+		adm, ok := b.allow()
+		if !ok {
+			t.Fatal("rejected while closed")
+		}
+		adm.done(err)
+	}
+	if s := b.stats(); s.State != BreakerClosed || s.ConsecutiveFailures != 0 || s.Failures != 2 {
+		t.Fatalf("stats: %+v", s)
+	}
+
+	for i := 0; i < 2; i++ {
+		adm, _ := b.allow()
+		adm.done(unavailable)
+	}
+	if _, ok := b.allow(); ok || b.stats().State != BreakerOpen {
+		t.Fatalf("not open: %+v", b.stats())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := b.allow()
+	if !ok || !probe.probe || b.stats().State != BreakerHalfOpen {
+		t.Fatalf("not half-open: %+v", b.stats())
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatal("second probe allowed")
+	}
+	probe.done(unavailable)
+	if _, ok := b.allow(); ok || b.stats().State != BreakerOpen {
+		t.Fatalf("not reopened: %+v", b.stats())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, _ = b.allow()
+	probe.done(nil)
+	if _, ok := b.allow(); !ok || b.stats().State != BreakerClosed {
+		t.Fatalf("not closed: %+v", b.stats())
+	}
+}
+
+func TestBreaker_FailureRate(t *testing.T) {
+	b := newTestBreaker(BreakerConfig{FailureRate: 0.5, MinRequests: 4})
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	for _, err := range []error{unavailable, nil, unavailable} {
+		adm, _ := b.allow()
+		adm.done(err)
+	}
+	if b.stats().State != BreakerClosed {
+		t.Fatal("opened before min requests")
+	}
+	// 熔断前放行的请求在熔断后结束不影响状态
+	late, _ := b.allow()
+	adm, _ := b.allow()
+	adm.done(unavailable)
+	if b.stats().State != BreakerOpen {
+		t.Fatalf("not open: %+v", b.stats())
+	}
+	late.done(nil)
+	if b.stats().State != BreakerOpen {
+		t.Fatalf("not open: %+v", b.stats())
+	}
+}
+
+func TestEngine_CircuitBreaker(t *testing.T) {
+	var (
+		calls atomic.Int32
+		fail  atomic.Bool
+	)
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		calls.Add(1)
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		if fail.Load() {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return stream.SendMsg([]byte("ok"))
+	})
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"mock": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.CircuitBreaker(BreakerConfig{ConsecutiveFailures: 2, OpenTimeout: 50 * time.Millisecond})
+	e := builder.Build()
+	err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: mockServerCall("mock")}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func() *mockResponse {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		return resp
+	}
+
+	fail.Store(true)
+	for i := 0; i < 3; i++ {
+		if resp := serve(); resp.statusCode != http.StatusServiceUnavailable {
+			t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("calls: %d", n)
+	}
+	if s := e.Breakers()["mock"]; s.State != BreakerOpen {
+		t.Fatalf("breaker: %+v", s)
+	}
+
+	fail.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if resp := serve(); string(resp.data) != "ok" {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+	if s := e.Breakers()["mock"]; s.State != BreakerClosed {
+		t.Fatalf("breaker: %+v", s)
+	}
+
+	// 重建后移除的 server 不再出现
+	err = e.RebuildRouter(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := e.Breakers(); len(stats) != 0 {
+		t.Fatalf("breakers: %v", stats)
+	}
+}
+
+// TestEngine_CircuitBreakerClientTimeout 检查客户端指定的超时不会触发熔断，Call.Timeout 的超时仍然计入
+func TestEngine_CircuitBreakerClientTimeout(t *testing.T) {
+	var calls atomic.Int32
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		calls.Add(1)
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		return stream.SendMsg([]byte("ok"))
+	})
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"mock": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.CircuitBreaker(BreakerConfig{ConsecutiveFailures: 2, OpenTimeout: time.Minute})
+	e := builder.Build()
+	defer e.ClearRouter()
+	call := mockServerCall("mock")
+	call.Timeout = 50 * time.Millisecond
+	err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: call}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(timeout string) *mockResponse {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+		if timeout != "" {
+			req.Header.Set(grpcTimeoutHeader, timeout)
+		}
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		return resp
+	}
+
+	for i := 0; i < 3; i++ {
+		if resp := serve("5m"); resp.statusCode != http.StatusGatewayTimeout {
+			t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+		}
+	}
+	if s := e.Breakers()["mock"]; s.State != BreakerClosed || s.Failures != 0 {
+		t.Fatalf("breaker: %+v", s)
+	}
+
+	// 客户端的超时超过 Call.Timeout 时使用 Call.Timeout，超时计入统计
+	for i := 0; i < 2; i++ {
+		if resp := serve("1S"); resp.statusCode != http.StatusGatewayTimeout {
+			t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+		}
+	}
+	if s := e.Breakers()["mock"]; s.State != BreakerOpen {
+		t.Fatalf("breaker: %+v", s)
+	}
+	if n := calls.Load(); n != 5 {
+		t.Fatalf("calls: %d", n)
+	}
+}
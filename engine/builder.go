@@ -1,12 +1,16 @@
 package engine
 
 import (
+	"context"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/vizee/gapi/internal/slices"
+	"github.com/vizee/gapi/metadata"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -15,7 +19,11 @@ type GrpcDialer struct {
 }
 
 func (d *GrpcDialer) Dial(server string) (*grpc.ClientConn, error) {
-	return grpc.Dial(server, d.Opts...)
+	return d.DialContext(context.Background(), server)
+}
+
+func (d *GrpcDialer) DialContext(ctx context.Context, server string) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, server, d.Opts...)
 }
 
 type Builder struct {
@@ -39,9 +47,11 @@ func NewBuilder() *Builder {
 				http.NotFound(ctx.resp, ctx.req)
 				return nil
 			},
+			onError:       defaultErrorHandler,
+			statusMapping: copyStatusMapping(defaultStatusMapping),
 		},
 	}
-	b.engine.router.Store(httprouter.New())
+	b.engine.gen.Store(&generation{router: httprouter.New()})
 	return b
 }
 
@@ -57,15 +67,68 @@ func (b *Builder) Use(use HandleFunc) {
 	b.engine.uses = append(b.engine.uses, use)
 }
 
+// Forward 添加对所有路由生效的 metadata 转发规则，先于 Call.Forward 应用
+func (b *Builder) Forward(rules ...metadata.ForwardRule) {
+	b.engine.forwards = append(b.engine.forwards, rules...)
+}
+
 func (b *Builder) Dialer(dialer Dialer) {
 	b.engine.dialer = dialer
 }
 
+// Upstream 定义名为 name 的 upstream，Call.Server 等于 name 的路由会按 upstream 的策略连接多个地址
+func (b *Builder) Upstream(name string, upstream *Upstream) {
+	if b.engine.upstreams == nil {
+		b.engine.upstreams = make(map[string]*Upstream)
+	}
+	b.engine.upstreams[name] = upstream
+}
+
+// HealthCheck 开启对所有 server 的定期健康检查，不健康的 server 上的调用会改用 Call.Fallback 或者直接返回 503
+func (b *Builder) HealthCheck(cfg HealthCheckConfig) {
+	if cfg.Interval <= 0 {
+		b.engine.health = nil
+		return
+	}
+	b.engine.health = newHealthChecker(cfg)
+}
+
+// CircuitBreaker 为每个 server 开启熔断，熔断期间的调用会改用 Call.Fallback 或者直接返回 503
+func (b *Builder) CircuitBreaker(cfg BreakerConfig) {
+	b.engine.breakers = newBreakerSet(cfg)
+}
+
+// ConcurrentDial 让重建 router 时并发地建立新连接，最多等待 timeout，超时或失败的 server 只影响使用它的路由
+func (b *Builder) ConcurrentDial(timeout time.Duration) {
+	b.engine.dialTimeout = timeout
+}
+
+// ConnPool 设置每个 server 建立的连接数，调用在这些连接间轮流分配，默认 1
+func (b *Builder) ConnPool(size int) {
+	b.engine.poolSize = size
+}
+
 func (b *Builder) NotFound(notFound HandleFunc) {
 	b.engine.notFound = notFound
 }
 
+// DrainTimeout 设置旧连接等待请求结束的最长时间，超时后强制关闭，默认一直等待
+func (b *Builder) DrainTimeout(timeout time.Duration) {
+	b.engine.drainTimeout = timeout
+}
+
+// ErrorHandler 设置所有路由的错误处理，优先于 CallHandler 实现的 ErrorWriter
+func (b *Builder) ErrorHandler(onError ErrorHandler) {
+	b.engine.onError = onError
+	b.engine.customError = true
+}
+
+func (b *Builder) MapStatus(code codes.Code, httpStatus int) {
+	b.engine.statusMapping[code] = httpStatus
+}
+
 func (b *Builder) Build() *Engine {
 	b.engine.uses = slices.Shrink(b.engine.uses)
+	b.engine.forwards = slices.Shrink(b.engine.forwards)
 	return b.engine
 }
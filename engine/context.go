@@ -3,9 +3,11 @@ package engine
 import (
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/vizee/gapi/internal/ioutil"
+	grpcmd "google.golang.org/grpc/metadata"
 )
 
 type Params httprouter.Params
@@ -20,15 +22,22 @@ func (ps Params) Get(name string) (string, bool) {
 }
 
 type Context struct {
-	req    *http.Request
-	resp   http.ResponseWriter
-	params Params
-	query  url.Values
-	values map[string]string
-	body   []byte
-	chain  []HandleFunc
-	handle HandleFunc
-	next   int
+	engine  *Engine
+	req     *http.Request
+	resp    http.ResponseWriter
+	params  Params
+	query   url.Values
+	values  map[string]string
+	body    []byte
+	header  grpcmd.MD
+	trailer grpcmd.MD
+	chain   []HandleFunc
+	handle  HandleFunc
+	next    int
+	start   time.Time
+	called  bool
+	// clientDeadline 表示调用的期限来自客户端指定的超时，而不是 Call.Timeout
+	clientDeadline bool
 }
 
 func (c *Context) Request() *http.Request {
@@ -85,6 +94,20 @@ func (c *Context) ReadBody() ([]byte, error) {
 	return c.body, nil
 }
 
+// GrpcHeader 返回后端响应的 header，在调用完成前为 nil
+func (c *Context) GrpcHeader() grpcmd.MD {
+	return c.header
+}
+
+// GrpcTrailer 返回后端响应的 trailer，在调用完成前为 nil
+func (c *Context) GrpcTrailer() grpcmd.MD {
+	return c.trailer
+}
+
+func (c *Context) ErrorStatus(err error) int {
+	return c.engine.HTTPStatus(err)
+}
+
 func (c *Context) Next() error {
 	// 分开 chain 和 handle 为了让 chain 复用
 	if c.next == len(c.chain) {
@@ -99,13 +122,19 @@ func (c *Context) Next() error {
 }
 
 func (c *Context) reset() {
+	c.engine = nil
 	c.req = nil
 	c.resp = nil
 	c.params = nil
 	c.query = nil
 	c.values = nil
 	c.body = nil
+	c.header = nil
+	c.trailer = nil
 	c.chain = nil
 	c.handle = nil
 	c.next = 0
+	c.start = time.Time{}
+	c.called = false
+	c.clientDeadline = false
 }
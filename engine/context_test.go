@@ -42,13 +42,16 @@ func TestContext_Get(t *testing.T) {
 
 func TestContext_reset(t *testing.T) {
 	ctx := &Context{
-		req:    &http.Request{},
-		resp:   nil,
-		params: []httprouter.Param{},
-		query:  map[string][]string{},
-		values: map[string]string{},
-		body:   []byte{},
-		chain:  []HandleFunc{},
+		engine:  &Engine{},
+		req:     &http.Request{},
+		resp:    nil,
+		params:  []httprouter.Param{},
+		query:   map[string][]string{},
+		values:  map[string]string{},
+		body:    []byte{},
+		header:  map[string][]string{},
+		trailer: map[string][]string{},
+		chain:   []HandleFunc{},
 		handle: func(ctx *Context) error {
 			return nil
 		},
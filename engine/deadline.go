@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcTimeoutHeader    = "Grpc-Timeout"
+	requestTimeoutHeader = "X-Request-Timeout"
+)
+
+// parseGrpcTimeout 解析 grpc-timeout 头部，格式为不超过 8 位的正整数加单位 H、M、S、m、u 或 n
+func parseGrpcTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 || len(s) > 9 {
+		return 0, false
+	}
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	// 超过 time.Duration 范围的值视为不限制
+	if n > uint64(1<<63-1)/uint64(unit) {
+		return 1<<63 - 1, true
+	}
+	return time.Duration(n) * unit, true
+}
+
+// parseRequestTimeout 解析 X-Request-Timeout 头部，支持 time.ParseDuration 的格式，纯数字视为毫秒
+func parseRequestTimeout(s string) (time.Duration, bool) {
+	if n, err := strconv.ParseUint(s, 10, 63); err == nil {
+		if n > uint64(1<<63-1)/uint64(time.Millisecond) {
+			return 1<<63 - 1, true
+		}
+		return time.Duration(n) * time.Millisecond, true
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// clientTimeout 返回客户端在请求头部中指定的超时，grpc-timeout 优先
+func (c *Context) clientTimeout() (time.Duration, bool, error) {
+	if v := c.req.Header.Get(grpcTimeoutHeader); v != "" {
+		d, ok := parseGrpcTimeout(v)
+		if !ok {
+			return 0, false, status.Errorf(codes.InvalidArgument, "malformed grpc-timeout %q", v)
+		}
+		return d, true, nil
+	}
+	if v := c.req.Header.Get(requestTimeoutHeader); v != "" {
+		d, ok := parseRequestTimeout(v)
+		if !ok {
+			return 0, false, status.Errorf(codes.InvalidArgument, "malformed %s %q", requestTimeoutHeader, v)
+		}
+		return d, true, nil
+	}
+	return 0, false, nil
+}
+
+// deadline 计算调用的截止时间。客户端指定超时时从收到请求开始计算，并且不超过 Call.Timeout，
+// 中间件和读取请求花费的时间会从中扣除，期限已经用完时返回 DeadlineExceeded 而不发起调用。
+// 否则与原来一样从调用开始计算 Call.Timeout，都没有时返回 false
+func (r *grpcRoute) deadline(ctx *Context) (time.Time, bool, error) {
+	timeout, ok, err := ctx.clientTimeout()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if !ok {
+		if r.call.Timeout > 0 {
+			return time.Now().Add(r.call.Timeout), true, nil
+		}
+		return time.Time{}, false, nil
+	}
+	if r.call.Timeout > 0 && timeout >= r.call.Timeout {
+		timeout = r.call.Timeout
+	} else {
+		ctx.clientDeadline = true
+	}
+	elapsed := time.Since(ctx.start)
+	if elapsed >= timeout {
+		return time.Time{}, false, status.Errorf(codes.DeadlineExceeded, "request timeout %v exhausted before calling %s (%v elapsed)", timeout, r.call.Method, elapsed.Round(time.Microsecond))
+	}
+	return ctx.start.Add(timeout), true, nil
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+)
+
+func TestParseGrpcTimeout(t *testing.T) {
+	tests := []struct {
+		s  string
+		d  time.Duration
+		ok bool
+	}{
+		{"1S", time.Second, true},
+		{"250m", 250 * time.Millisecond, true},
+		{"3H", 3 * time.Hour, true},
+		{"99999999n", 99999999, true},
+		{"123456789S", 0, false},
+		{"S", 0, false},
+		{"10x", 0, false},
+		{"-1S", 0, false},
+	}
+	for _, tt := range tests {
+		d, ok := parseGrpcTimeout(tt.s)
+		if d != tt.d || ok != tt.ok {
+			t.Errorf("parseGrpcTimeout(%q) = %v, %v", tt.s, d, ok)
+		}
+	}
+	if d, ok := parseRequestTimeout("1500"); !ok || d != 1500*time.Millisecond {
+		t.Errorf("parseRequestTimeout(1500) = %v, %v", d, ok)
+	}
+	if d, ok := parseRequestTimeout("1.5s"); !ok || d != 1500*time.Millisecond {
+		t.Errorf("parseRequestTimeout(1.5s) = %v, %v", d, ok)
+	}
+	if _, ok := parseRequestTimeout("-1s"); ok {
+		t.Error("negative timeout accepted")
+	}
+}
+
+func TestEngine_DeadlinePropagation(t *testing.T) {
+	var calls atomic.Int32
+	// server 返回收到的剩余期限
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		calls.Add(1)
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		deadline, ok := stream.Context().Deadline()
+		if !ok {
+			return stream.SendMsg([]byte("none"))
+		}
+		return stream.SendMsg([]byte(time.Until(deadline).String()))
+	})
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"s": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.RegisterMiddleware("slow", func(ctx *Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return ctx.Next()
+	})
+	e := builder.Build()
+
+	call := mockServerCall("s")
+	call.Timeout = 200 * time.Millisecond
+	unbounded := mockServerCall("s")
+	unbounded.Timeout = 0
+	err := e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: call},
+		{Method: "POST", Path: "/slow", Use: []string{"slow"}, Call: call},
+		{Method: "POST", Path: "/unbounded", Call: unbounded},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(path string, header string, value string) *mockResponse {
+		req, _ := http.NewRequest("POST", "http://localhost"+path, strings.NewReader(`{}`))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		return resp
+	}
+	remaining := func(resp *mockResponse) time.Duration {
+		d, err := time.ParseDuration(string(resp.data))
+		if err != nil {
+			t.Fatalf("response: %d %q", resp.statusCode, resp.data)
+		}
+		return d
+	}
+
+	if d := remaining(serve("/add", "", "")); d <= 100*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatalf("default deadline: %v", d)
+	}
+	if d := remaining(serve("/add", "X-Request-Timeout", "50")); d > 50*time.Millisecond {
+		t.Fatalf("client deadline: %v", d)
+	}
+	if d := remaining(serve("/add", "grpc-timeout", "1H")); d > 200*time.Millisecond {
+		t.Fatalf("clamped deadline: %v", d)
+	}
+	if d := remaining(serve("/unbounded", "grpc-timeout", "1H")); d <= 59*time.Minute {
+		t.Fatalf("unbounded deadline: %v", d)
+	}
+	if resp := serve("/unbounded", "", ""); string(resp.data) != "none" {
+		t.Fatalf("no deadline: %q", resp.data)
+	}
+	if d := remaining(serve("/slow", "X-Request-Timeout", "100ms")); d > 80*time.Millisecond {
+		t.Fatalf("middleware time not subtracted: %v", d)
+	}
+
+	before := calls.Load()
+	if resp := serve("/slow", "X-Request-Timeout", "10ms"); resp.statusCode != http.StatusGatewayTimeout {
+		t.Fatalf("exhausted budget: %d %q", resp.statusCode, resp.data)
+	}
+	if resp := serve("/add", "grpc-timeout", "soon"); resp.statusCode != http.StatusBadRequest {
+		t.Fatalf("malformed timeout: %d %q", resp.statusCode, resp.data)
+	}
+	if calls.Load() != before {
+		t.Fatal("backend called after budget exhausted")
+	}
+}
+
+func TestEngine_DeadlineBreaker(t *testing.T) {
+	var calls atomic.Int32
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		calls.Add(1)
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg([]byte("ok"))
+	})
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"s": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.CircuitBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: 20 * time.Millisecond})
+	e := builder.Build()
+	err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: mockServerCall("s")}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(timeout string) int {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+		if timeout != "" {
+			req.Header.Set("grpc-timeout", timeout)
+		}
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		if resp.statusCode == 0 {
+			return http.StatusOK
+		}
+		return resp.statusCode
+	}
+
+	// 调用前失败的请求不影响熔断器
+	for i := 0; i < 5; i++ {
+		if code := serve("1n"); code != http.StatusGatewayTimeout {
+			t.Fatalf("exhausted budget: %d", code)
+		}
+		if code := serve("bad"); code != http.StatusBadRequest {
+			t.Fatalf("malformed timeout: %d", code)
+		}
+	}
+	if s := e.Breakers()["s"]; s.State != BreakerClosed || s.Requests != 0 {
+		t.Fatalf("breaker: %+v", s)
+	}
+	if code := serve(""); code != http.StatusOK || calls.Load() != 1 {
+		t.Fatalf("response %d, calls %d", code, calls.Load())
+	}
+
+	// 半开状态下调用前失败的请求归还探测名额
+	b := e.breakers.get("s")
+	b.mu.Lock()
+	b.setState(BreakerOpen, time.Now().Add(-time.Second))
+	b.mu.Unlock()
+	if code := serve("1n"); code != http.StatusGatewayTimeout {
+		t.Fatalf("exhausted budget: %d", code)
+	}
+	if s := b.stats(); s.State != BreakerHalfOpen {
+		t.Fatalf("breaker: %+v", s)
+	}
+	if code := serve(""); code != http.StatusOK {
+		t.Fatalf("probe: %d", code)
+	}
+	if s := b.stats(); s.State != BreakerClosed {
+		t.Fatalf("breaker: %+v", s)
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// barrierDialer 中 a 和 b 互相等待对方开始建立连接，只有并发建立连接时才能完成；slow 在 release 之后才完成
+type barrierDialer struct {
+	started sync.WaitGroup
+	release chan struct{}
+
+	mu   sync.Mutex
+	late *grpc.ClientConn
+}
+
+func (d *barrierDialer) Dial(server string) (*grpc.ClientConn, error) {
+	switch server {
+	case "a", "b":
+		d.started.Done()
+		d.started.Wait()
+	case "slow":
+		<-d.release
+	}
+	cc, err := grpc.Dial(server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if server == "slow" {
+		d.mu.Lock()
+		d.late = cc
+		d.mu.Unlock()
+	}
+	return cc, err
+}
+
+func TestEngine_ConcurrentDial(t *testing.T) {
+	d := &barrierDialer{release: make(chan struct{})}
+	d.started.Add(2)
+	builder := NewBuilder()
+	builder.Dialer(d)
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.ConcurrentDial(time.Second)
+	e := builder.Build()
+	defer e.ClearRouter()
+
+	noHandler := mockServerCall("unused")
+	noHandler.Handler = "unknown"
+	start := time.Now()
+	result, err := e.RebuildRouterResult([]*metadata.Route{
+		{Method: "POST", Path: "/a", Call: mockServerCall("a")},
+		{Method: "POST", Path: "/b", Call: mockServerCall("b")},
+		{Method: "POST", Path: "/slow", Call: mockServerCall("slow")},
+		{Method: "POST", Path: "/unused", Call: noHandler},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("rebuild took %v", elapsed)
+	}
+	if len(result.Accepted) != 2 || len(result.Rejected) != 2 {
+		t.Fatalf("result: %+v", result)
+	}
+	if rejected := result.Rejected[0]; rejected.Path != "/slow" || !strings.Contains(rejected.Err.Error(), "timeout") {
+		t.Fatalf("rejected: %+v", rejected)
+	}
+	if len(result.Dialed) != 2 || result.Dialed[0] != "a" || result.Dialed[1] != "b" {
+		t.Fatalf("dialed: %v", result.Dialed)
+	}
+
+	close(d.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.Lock()
+		late := d.late
+		d.mu.Unlock()
+		if late != nil && late.GetState() == connectivity.Shutdown {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("late connection not closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestEngine_ConcurrentDialCancel 检查使用 grpc.WithBlock 时超时的连接被取消，不会留下阻塞的 goroutine
+func TestEngine_ConcurrentDialCancel(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 关闭监听后这个地址无法连接，阻塞的 Dial 会一直重试
+	addr := lis.Addr().String()
+	lis.Close()
+
+	before := runtime.NumGoroutine()
+	for _, dialer := range []Dialer{
+		&GrpcDialer{Opts: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock()}},
+		&TLSDialer{Opts: []grpc.DialOption{grpc.WithBlock()}},
+	} {
+		builder := NewBuilder()
+		builder.Dialer(dialer)
+		builder.RegisterHandler("mock-handler", &mockHandler{})
+		builder.ConcurrentDial(50 * time.Millisecond)
+		e := builder.Build()
+		result, err := e.RebuildRouterResult([]*metadata.Route{
+			{Method: "POST", Path: "/a", Call: mockServerCall(addr)},
+			{Method: "POST", Path: "/b", Call: mockServerCall(addr + "," + addr)},
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Rejected) != 2 {
+			t.Fatalf("result: %+v", result)
+		}
+		e.ClearRouter()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines: %d, before %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vizee/gapi/log"
+)
+
+// generation 是一次 router 构建的结果，请求在整个处理过程中持有所在的 generation
+type generation struct {
+	id        uint64
+	router    *httprouter.Router
+	clients   map[string]*connPool
+	inflight  atomic.Int64
+	retired   atomic.Bool
+	retiredAt time.Time
+}
+
+type GenerationStats struct {
+	ID        uint64
+	Current   bool
+	InFlight  int64
+	RetiredAt time.Time
+}
+
+func (e *Engine) enterGeneration() *generation {
+	for {
+		gen := e.gen.Load()
+		if gen == nil {
+			return nil
+		}
+		gen.inflight.Add(1)
+		// 先计数再检查 retired，保证 reap 不会错过已经进入的请求，退役后进入的请求转到新的 generation
+		if !gen.retired.Load() {
+			return gen
+		}
+		e.leaveGeneration(gen)
+	}
+}
+
+func (e *Engine) leaveGeneration(gen *generation) {
+	if gen.inflight.Add(-1) == 0 && gen.retired.Load() {
+		e.drainLock.Lock()
+		e.reapLocked()
+		e.drainLock.Unlock()
+	}
+}
+
+// retire 退役 gen，并在旧请求结束后关闭 orphans 中的连接，调用方需要持有 routeLock
+func (e *Engine) retire(gen *generation, orphans map[string]*connPool) {
+	e.drainLock.Lock()
+	defer e.drainLock.Unlock()
+
+	if gen != nil {
+		gen.retiredAt = time.Now()
+		gen.retired.Store(true)
+		e.draining = append(e.draining, gen)
+	}
+	for server, cc := range orphans {
+		if e.orphans == nil {
+			e.orphans = make(map[*connPool]string)
+		}
+		e.orphans[cc] = server
+		if e.drainTimeout > 0 {
+			cc := cc
+			time.AfterFunc(e.drainTimeout, func() {
+				e.forceClose(cc)
+			})
+		}
+	}
+	e.reapLocked()
+}
+
+func (e *Engine) referenced(cc *connPool, server string) bool {
+	for _, gen := range e.draining {
+		if gen.clients[server] == cc {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) reapLocked() {
+	n := 0
+	for _, gen := range e.draining {
+		if gen.inflight.Load() > 0 {
+			e.draining[n] = gen
+			n++
+		}
+	}
+	for i := n; i < len(e.draining); i++ {
+		e.draining[i] = nil
+	}
+	e.draining = e.draining[:n]
+
+	for cc, server := range e.orphans {
+		if !e.referenced(cc, server) {
+			delete(e.orphans, cc)
+			cc.Close()
+			log.Debugf("Drained connection %s closed", server)
+		}
+	}
+}
+
+func (e *Engine) forceClose(cc *connPool) {
+	e.drainLock.Lock()
+	server, ok := e.orphans[cc]
+	if ok {
+		delete(e.orphans, cc)
+	}
+	e.drainLock.Unlock()
+	if ok {
+		cc.Close()
+		log.Warnf("Connection %s closed after drain timeout", server)
+	}
+}
+
+// Generations 返回当前 generation 以及仍有请求未结束的旧 generation
+func (e *Engine) Generations() []GenerationStats {
+	var stats []GenerationStats
+	cur := e.gen.Load()
+	if cur != nil {
+		stats = append(stats, GenerationStats{
+			ID:       cur.id,
+			Current:  true,
+			InFlight: cur.inflight.Load(),
+		})
+	}
+	e.drainLock.Lock()
+	for _, gen := range e.draining {
+		stats = append(stats, GenerationStats{
+			ID:        gen.id,
+			InFlight:  gen.inflight.Load(),
+			RetiredAt: gen.retiredAt,
+		})
+	}
+	e.drainLock.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ID > stats[j].ID
+	})
+	return stats
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+type connDialer map[string]*grpc.ClientConn
+
+func (d connDialer) Dial(server string) (*grpc.ClientConn, error) {
+	return d[server], nil
+}
+
+func TestEngine_DrainConnections(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	cc := startMockServer(t, func(srv any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		close(entered)
+		<-release
+		return stream.SendMsg(req)
+	})
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"mock": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	e := builder.Build()
+
+	result, err := e.RebuildRouterResult([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("mock")},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := result.Generation
+
+	done := make(chan *mockResponse, 1)
+	go func() {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`hello`))
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		done <- resp
+	}()
+	<-entered
+
+	result, err = e.RebuildRouterResult(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Closed) != 1 || result.Closed[0] != "mock" {
+		t.Fatalf("closed: %v", result.Closed)
+	}
+	stats := e.Generations()
+	if len(stats) != 2 || !stats[0].Current || stats[0].ID != result.Generation || stats[1].ID != first || stats[1].InFlight != 1 {
+		t.Fatalf("generations: %+v", stats)
+	}
+	if cc.GetState() == connectivity.Shutdown {
+		t.Fatal("connection closed while in flight")
+	}
+
+	close(release)
+	resp := <-done
+	if resp.statusCode != 0 || string(resp.data) != "hello" {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+	if cc.GetState() != connectivity.Shutdown {
+		t.Fatal("connection not closed after drain")
+	}
+	stats = e.Generations()
+	if len(stats) != 1 || !stats[0].Current {
+		t.Fatalf("generations: %+v", stats)
+	}
+}
+
+func TestEngine_DrainTimeout(t *testing.T) {
+	entered := make(chan struct{})
+	cc := startMockServer(t, func(srv any, stream grpc.ServerStream) error {
+		close(entered)
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	})
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"mock": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.DrainTimeout(50 * time.Millisecond)
+	e := builder.Build()
+	err := e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("mock")},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`hello`))
+		e.ServeHTTP(&mockResponse{}, req)
+		close(done)
+	}()
+	<-entered
+	e.ClearRouter()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request not aborted after drain timeout")
+	}
+	if cc.GetState() != connectivity.Shutdown {
+		t.Fatal("connection not closed")
+	}
+	if stats := e.Generations(); len(stats) != 0 {
+		t.Fatalf("generations: %+v", stats)
+	}
+}
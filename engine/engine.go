@@ -1,16 +1,20 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/vizee/gapi/log"
 	"github.com/vizee/gapi/metadata"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 type HandleFunc func(ctx *Context) error
@@ -19,22 +23,71 @@ type Dialer interface {
 	Dial(server string) (*grpc.ClientConn, error)
 }
 
+// ContextDialer 由 Dialer 选择实现，ConcurrentDial 超时后通过 ctx 取消还没有完成的连接，
+// 例如使用 grpc.WithBlock 时阻塞的连接
+type ContextDialer interface {
+	DialContext(ctx context.Context, server string) (*grpc.ClientConn, error)
+}
+
 type CallHandler interface {
 	ReadRequest(call *metadata.Call, ctx *Context) ([]byte, error)
 	WriteResponse(call *metadata.Call, ctx *Context, data []byte) error
 }
 
+// ErrorHandler 输出路由上的错误，err 为 StreamError 时响应已经开始，只能用于记录
+type ErrorHandler func(ctx *Context, err error)
+
+// ErrorWriter 由 CallHandler 选择实现，没有通过 Builder.ErrorHandler 设置错误处理时，路由上的错误由 CallHandler 自行输出
+type ErrorWriter interface {
+	WriteError(call *metadata.Call, ctx *Context, err error) error
+}
+
 type Engine struct {
 	middlewares map[string]HandleFunc
 	handlers    map[string]CallHandler
 	uses        []HandleFunc
+	forwards    []metadata.ForwardRule
 	dialer      Dialer
+	upstreams   map[string]*Upstream
+	health      *healthChecker
+	breakers    *breakerSet
+	hedgeStats  hedgeCounters
+	dialTimeout time.Duration
+	poolSize    int
 	notFound    HandleFunc
+	onError     ErrorHandler
+	// customError 表示 onError 由 Builder.ErrorHandler 设置，优先于 ErrorWriter
+	customError bool
 	ctxpool     *sync.Pool
 
-	router    atomic.Pointer[httprouter.Router]
-	clients   map[string]*grpc.ClientConn
+	statusMapping map[codes.Code]int
+
+	gen       atomic.Pointer[generation]
+	nextGen   uint64
+	clients   map[string]*connPool
 	routeLock sync.Mutex
+
+	drainTimeout time.Duration
+	drainLock    sync.Mutex
+	draining     []*generation
+	orphans      map[*connPool]string
+}
+
+func supportStream(ch CallHandler, stream metadata.StreamType) bool {
+	switch stream {
+	case metadata.NoStream:
+		return true
+	case metadata.ServerStream:
+		_, ok := ch.(ServerStreamHandler)
+		return ok
+	case metadata.ClientStream:
+		_, ok := ch.(ClientStreamHandler)
+		return ok
+	case metadata.BidiStream:
+		_, ok := ch.(BidiStreamHandler)
+		return ok
+	}
+	return false
 }
 
 func (e *Engine) generateMiddlewareChain(cache map[string][]HandleFunc, middlewares []string) ([]HandleFunc, error) {
@@ -59,15 +112,22 @@ func (e *Engine) generateMiddlewareChain(cache map[string][]HandleFunc, middlewa
 	return mws, nil
 }
 
+func (e *Engine) Dialer() Dialer {
+	return e.dialer
+}
+
 func (e *Engine) ClearRouter() {
 	e.routeLock.Lock()
 	clients := e.clients
 	e.clients = nil
-	e.router.Store(nil)
-	e.routeLock.Unlock()
-	for _, cc := range clients {
-		cc.Close()
+	if e.health != nil {
+		e.health.sync(nil)
 	}
+	if e.breakers != nil {
+		e.breakers.sync(nil)
+	}
+	e.retire(e.gen.Swap(nil), clients)
+	e.routeLock.Unlock()
 }
 
 type routesSliceIter struct {
@@ -77,7 +137,9 @@ type routesSliceIter struct {
 
 func (it *routesSliceIter) NextRoute() *metadata.Route {
 	if it.i < len(it.rs) {
-		return it.rs[it.i]
+		r := it.rs[it.i]
+		it.i++
+		return r
 	}
 	return nil
 }
@@ -86,6 +148,10 @@ func (e *Engine) RebuildRouter(routes []*metadata.Route, ignoreError bool) error
 	return RebuildEngineRouter(e, &routesSliceIter{rs: routes}, ignoreError)
 }
 
+func (e *Engine) RebuildRouterResult(routes []*metadata.Route, ignoreError bool) (*RebuildResult, error) {
+	return RebuildEngineRouterResult(e, &routesSliceIter{rs: routes}, ignoreError)
+}
+
 func registerRoute(router *httprouter.Router, method string, path string, handle httprouter.Handle) (err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -96,18 +162,46 @@ func registerRoute(router *httprouter.Router, method string, path string, handle
 	return
 }
 
+func defaultErrorHandler(ctx *Context, err error) {
+	var se *StreamError
+	if errors.As(err, &se) {
+		return
+	}
+	code := ctx.ErrorStatus(err)
+	http.Error(ctx.resp, http.StatusText(code), code)
+}
+
+func (e *Engine) routeErrorHandler(call *metadata.Call, ch CallHandler) ErrorHandler {
+	ew, ok := ch.(ErrorWriter)
+	if !ok || e.customError {
+		return e.onError
+	}
+	return func(ctx *Context, err error) {
+		werr := ew.WriteError(call, ctx, err)
+		if werr != nil {
+			log.Errorf("WriteError %s: %v", ctx.req.URL.Path, werr)
+		}
+	}
+}
+
 func (e *Engine) Execute(w http.ResponseWriter, req *http.Request, params Params, chain []HandleFunc, handle HandleFunc) {
+	e.execute(w, req, params, chain, handle, e.onError)
+}
+
+func (e *Engine) execute(w http.ResponseWriter, req *http.Request, params Params, chain []HandleFunc, handle HandleFunc, onError ErrorHandler) {
 	ctx := e.ctxpool.Get().(*Context)
+	ctx.engine = e
 	ctx.req = req
 	ctx.resp = w
 	ctx.params = params
 	ctx.chain = chain
 	ctx.handle = handle
+	ctx.start = time.Now()
 
 	err := ctx.Next()
 	if err != nil {
 		log.Errorf("Execute %s: %v", req.URL.Path, err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		onError(ctx, err)
 	}
 
 	ctx.reset()
@@ -121,10 +215,11 @@ func (e *Engine) NotFound(w http.ResponseWriter, req *http.Request) {
 func (e *Engine) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log.Debugf("Route %s %s", req.Method, req.URL.Path)
 
-	router := e.router.Load()
-	if router != nil {
+	gen := e.enterGeneration()
+	if gen != nil {
+		defer e.leaveGeneration(gen)
 		path := req.URL.Path
-		handle, ps, tsr := router.Lookup(req.Method, path)
+		handle, ps, tsr := gen.router.Lookup(req.Method, path)
 		if handle != nil {
 			handle(w, req, ps)
 			return
@@ -144,86 +239,89 @@ type RouteIter interface {
 }
 
 func RebuildEngineRouter[R RouteIter](e *Engine, routeIter R, ignoreError bool) error {
+	_, err := RebuildEngineRouterResult(e, routeIter, ignoreError)
+	return err
+}
+
+// RebuildEngineRouterResult 与 RebuildEngineRouter 相同，但会返回每条路由的处理结果和连接的变化。
+// 返回错误时 router 不会被替换，result 中记录到出错为止的结果
+func RebuildEngineRouterResult[R RouteIter](e *Engine, routeIter R, ignoreError bool) (*RebuildResult, error) {
 	e.routeLock.Lock()
 	defer e.routeLock.Unlock()
 
-	old := e.clients
-	clients := make(map[string]*grpc.ClientConn)
+	b := &rebuilder{
+		engine:  e,
+		old:     e.clients,
+		clients: make(map[string]*connPool),
+		// 在同一次 router 构建中尽可能复用重复的 chain，在大量路由的情况下会带来一些内存节约
+		chainCache: make(map[string][]HandleFunc),
+		result:     &RebuildResult{},
+	}
+	result := b.result
 	defer func() {
-		for server, cc := range clients {
-			if old[server] == nil {
+		for server, cc := range b.clients {
+			if b.old[server] == nil {
 				cc.Close()
+				result.Closed = append(result.Closed, server)
 			}
 		}
+		b.closeUnused()
+		result.sort()
 	}()
 
-	// 在同一次 router 构建中尽可能复用重复的 chain，在大量路由的情况下会带来一些内存节约
-	chainCache := make(map[string][]HandleFunc)
+	nextRoute := routeIter.NextRoute
+	if e.dialTimeout > 0 {
+		routes := collectRoutes(routeIter)
+		b.dialAll(routes, e.dialTimeout)
+		nextRoute = (&routesSliceIter{rs: routes}).NextRoute
+	}
+
 	router := httprouter.New()
-	for {
-		route := routeIter.NextRoute()
+	for index := 0; ; index++ {
+		route := nextRoute()
 		if route == nil {
 			break
 		}
-		ch := e.handlers[route.Call.Handler]
-		if ch == nil {
-			if ignoreError {
-				continue
-			}
-			return fmt.Errorf("route %s handler %s not found", route.Path, route.Call.Handler)
-		}
-
-		// 建立连接，尽可能复用旧连接
-		client := clients[route.Call.Server]
-		if client == nil {
-			client = old[route.Call.Server]
-			if client == nil {
-				var err error
-				client, err = e.dialer.Dial(route.Call.Server)
-				if err != nil {
-					if ignoreError {
-						continue
-					}
-					return fmt.Errorf("dial %s: %w", route.Call.Server, err)
-				}
-			}
-			clients[route.Call.Server] = client
+		handle, err := b.routeHandle(route)
+		if err == nil {
+			err = registerRoute(router, route.Method, route.Path, handle)
 		}
-
-		middlewares, err := e.generateMiddlewareChain(chainCache, route.Use)
-		if err != nil {
-			if ignoreError {
-				continue
-			}
-			return fmt.Errorf("middleware of %s: %v", route.Path, err)
-		}
-
-		gr := &grpcRoute{
-			engine:      e,
-			middlewares: middlewares,
-			call:        route.Call,
-			ch:          ch,
-			client:      client,
-		}
-		err = registerRoute(router, route.Method, route.Path, gr.handleRoute)
 		if err != nil {
+			result.reject(index, route, err)
 			if ignoreError {
-				log.Warnf("registerRoute(%s %s): %v", route.Method, route.Path, err)
+				log.Warnf("Skip route %s %s: %v", route.Method, route.Path, err)
 				continue
 			}
-			return err
+			return result, err
 		}
+		result.accept(index, route)
 	}
 
-	e.router.Store(router)
-	e.clients = clients
-	for server, cc := range old {
-		if clients[server] == nil {
-			cc.Close()
+	e.nextGen++
+	gen := &generation{
+		id:      e.nextGen,
+		router:  router,
+		clients: b.clients,
+	}
+	result.Generation = gen.id
+	orphans := make(map[string]*connPool)
+	for server, cc := range b.old {
+		if b.clients[server] == nil {
+			orphans[server] = cc
+			result.Closed = append(result.Closed, server)
 		}
 	}
+	e.clients = b.clients
+	if e.health != nil {
+		e.health.sync(e.clients)
+	}
+	if e.breakers != nil {
+		e.breakers.sync(e.clients)
+	}
+	// 旧 generation 上的请求结束后才会关闭不再使用的连接
+	e.retire(e.gen.Swap(gen), orphans)
 	// 如果正常退出，确保新链接不会被关闭
-	clients = nil
+	b.clients = nil
 
-	return nil
+	return result, nil
 }
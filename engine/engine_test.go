@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"testing"
@@ -8,6 +9,19 @@ import (
 	"github.com/vizee/gapi/metadata"
 )
 
+func TestRoutesSliceIter(t *testing.T) {
+	rs := []*metadata.Route{{Path: "/a"}, {Path: "/b"}}
+	it := &routesSliceIter{rs: rs}
+	for i := range rs {
+		if r := it.NextRoute(); r != rs[i] {
+			t.Fatalf("route #%d: %v", i, r)
+		}
+	}
+	if r := it.NextRoute(); r != nil {
+		t.Fatalf("unexpected route: %v", r)
+	}
+}
+
 func TestEngine_RebuildRouter(t *testing.T) {
 	builder := NewBuilder()
 	builder.RegisterHandler("mock-handler", &mockHandler{})
@@ -40,3 +54,48 @@ func TestEngine_RebuildRouter(t *testing.T) {
 	e.ServeHTTP(resp, req)
 	t.Logf("response %d: %s", resp.statusCode, resp.data)
 }
+
+type mockErrorHandler struct {
+	mockHandler
+}
+
+func (*mockErrorHandler) WriteError(_ *metadata.Call, ctx *Context, err error) error {
+	ctx.Response().WriteHeader(ctx.ErrorStatus(err))
+	_, werr := ctx.Response().Write([]byte(`{"error":"` + err.Error() + `"}`))
+	return werr
+}
+
+func TestEngine_ErrorHandler(t *testing.T) {
+	builder := NewBuilder()
+	builder.ErrorHandler(func(ctx *Context, err error) {
+		ctx.Response().WriteHeader(http.StatusTeapot)
+	})
+	e := builder.Build()
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := func(ctx *Context) error {
+		return errors.New("failed")
+	}
+
+	resp := &mockResponse{}
+	e.Execute(resp, req, nil, nil, failed)
+	if resp.statusCode != http.StatusTeapot {
+		t.Fatalf("status %d", resp.statusCode)
+	}
+
+	// 设置了 ErrorHandler 时优先于 ErrorWriter
+	resp = &mockResponse{}
+	e.execute(resp, req, nil, nil, failed, e.routeErrorHandler(mockAddCall(), &mockErrorHandler{}))
+	if resp.statusCode != http.StatusTeapot {
+		t.Fatalf("status %d", resp.statusCode)
+	}
+
+	e = NewBuilder().Build()
+	resp = &mockResponse{}
+	e.execute(resp, req, nil, nil, failed, e.routeErrorHandler(mockAddCall(), &mockErrorHandler{}))
+	if resp.statusCode != http.StatusInternalServerError || string(resp.data) != `{"error":"failed"}` {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+}
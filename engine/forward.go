@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vizee/gapi/log"
+	"github.com/vizee/gapi/metadata"
+	grpcmd "google.golang.org/grpc/metadata"
+)
+
+// forwardName 返回转发使用的 key，没有设置 Rename 时保留原来的名字，前缀规则只替换前缀
+func forwardName(rule *metadata.ForwardRule, name string) string {
+	if rule.Rename == "" {
+		return name
+	}
+	if rule.Prefix {
+		return rule.Rename + name[len(rule.Name):]
+	}
+	return rule.Rename
+}
+
+// validMDKey 检查 key 是否可以作为 GRPC metadata 的 key，key 已经转换为小写
+func validMDKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// validMDValue 检查 value 是否可以发送，-bin 结尾的 key 的值会被 GRPC 编码，可以是任意内容
+func validMDValue(key string, value string) bool {
+	if strings.HasSuffix(key, "-bin") {
+		return true
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// appendMD 跳过 GRPC 不接受的 key 和 value，否则整个调用会以 Internal 失败
+func appendMD(md grpcmd.MD, key string, vals ...string) grpcmd.MD {
+	key = strings.ToLower(key)
+	if !validMDKey(key) {
+		log.Debugf("Skip forwarding invalid metadata key %q", key)
+		return md
+	}
+	for _, v := range vals {
+		if !validMDValue(key, v) {
+			log.Debugf("Skip forwarding invalid metadata value of %s", key)
+			continue
+		}
+		if md == nil {
+			md = make(grpcmd.MD)
+		}
+		md[key] = append(md[key], v)
+	}
+	return md
+}
+
+func appendForwardMD(md grpcmd.MD, ctx *Context, rules []metadata.ForwardRule) grpcmd.MD {
+	for i := range rules {
+		rule := &rules[i]
+		switch rule.Source {
+		case metadata.BindHeader:
+			header := ctx.req.Header
+			if !rule.Prefix {
+				md = appendMD(md, forwardName(rule, rule.Name), header.Values(rule.Name)...)
+				continue
+			}
+			for name, vals := range header {
+				// 头部名已经被规范化，前缀需要忽略大小写比较
+				if len(name) < len(rule.Name) || !strings.EqualFold(name[:len(rule.Name)], rule.Name) {
+					continue
+				}
+				md = appendMD(md, forwardName(rule, name), vals...)
+			}
+		case metadata.BindContext:
+			if !rule.Prefix {
+				val, ok := ctx.Get(rule.Name)
+				if !ok {
+					continue
+				}
+				md = appendMD(md, forwardName(rule, rule.Name), val)
+				continue
+			}
+			for name, val := range ctx.values {
+				if !strings.HasPrefix(name, rule.Name) {
+					continue
+				}
+				md = appendMD(md, forwardName(rule, name), val)
+			}
+		}
+	}
+	return md
+}
+
+func copyReturnHeaders(h http.Header, names []string, mds ...grpcmd.MD) {
+	for _, name := range names {
+		if strings.HasSuffix(name, "*") {
+			prefix := strings.ToLower(name[:len(name)-1])
+			for _, md := range mds {
+				for k, vals := range md {
+					if strings.HasPrefix(k, prefix) {
+						for _, v := range vals {
+							h.Add(k, v)
+						}
+					}
+				}
+			}
+			continue
+		}
+		for _, md := range mds {
+			for _, v := range md.Get(name) {
+				h.Add(name, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vizee/gapi/metadata"
+	grpcmd "google.golang.org/grpc/metadata"
+)
+
+func Test_appendForwardMD(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Tenant-Id", "42")
+	req.Header.Add("X-Trace-Id", "a")
+	req.Header.Add("X-Trace-Span", "b")
+	req.Header.Set("X-User", "José")
+	req.Header.Set("X-User-Bin", "José")
+	req.Header.Add("X-Name", "ok")
+	req.Header.Add("X-Name", "bad\x00")
+	req.Header.Set("X-Prefix", "v")
+	ctx := &Context{req: req}
+	ctx.Set("uid", "1")
+	ctx.Set("auth.role", "admin")
+	ctx.Set("bad key", "1")
+
+	tests := []struct {
+		name  string
+		rules []metadata.ForwardRule
+		want  grpcmd.MD
+	}{
+		{name: "empty", rules: nil, want: nil},
+		{name: "missing", rules: []metadata.ForwardRule{{Name: "X-Missing", Source: metadata.BindHeader}}, want: nil},
+		{name: "header", rules: []metadata.ForwardRule{
+			{Name: "Authorization", Source: metadata.BindHeader},
+			{Name: "X-Tenant-Id", Rename: "tenant", Source: metadata.BindHeader},
+		}, want: grpcmd.MD{"authorization": {"Bearer token"}, "tenant": {"42"}}},
+		{name: "header_prefix", rules: []metadata.ForwardRule{
+			{Name: "x-trace-", Prefix: true, Rename: "trace-", Source: metadata.BindHeader},
+		}, want: grpcmd.MD{"trace-id": {"a"}, "trace-span": {"b"}}},
+		{name: "context", rules: []metadata.ForwardRule{
+			{Name: "uid", Rename: "x-uid", Source: metadata.BindContext},
+			{Name: "auth.", Prefix: true, Rename: "x-auth-", Source: metadata.BindContext},
+		}, want: grpcmd.MD{"x-uid": {"1"}, "x-auth-role": {"admin"}}},
+		{name: "invalid_value", rules: []metadata.ForwardRule{
+			{Name: "X-User", Source: metadata.BindHeader},
+			{Name: "X-User-Bin", Source: metadata.BindHeader},
+			{Name: "X-Name", Source: metadata.BindHeader},
+		}, want: grpcmd.MD{"x-user-bin": {"José"}, "x-name": {"ok"}}},
+		{name: "invalid_key", rules: []metadata.ForwardRule{
+			{Name: "bad key", Source: metadata.BindContext},
+		}, want: nil},
+		{name: "prefix_keep_name", rules: []metadata.ForwardRule{
+			{Name: "X-Trace", Prefix: true, Source: metadata.BindHeader},
+			{Name: "X-Prefix", Prefix: true, Source: metadata.BindHeader},
+		}, want: grpcmd.MD{"x-trace-id": {"a"}, "x-trace-span": {"b"}, "x-prefix": {"v"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendForwardMD(nil, ctx, tt.rules); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("appendForwardMD() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_copyReturnHeaders(t *testing.T) {
+	header := grpcmd.MD{"x-ratelimit-limit": {"10"}, "x-ratelimit-remaining": {"9"}, "x-internal": {"1"}}
+	trailer := grpcmd.MD{"set-cookie": {"a=1", "b=2"}}
+	h := make(http.Header)
+	copyReturnHeaders(h, []string{"X-RateLimit-*", "set-cookie", "x-missing"}, header, trailer)
+	want := http.Header{
+		"X-Ratelimit-Limit":     {"10"},
+		"X-Ratelimit-Remaining": {"9"},
+		"Set-Cookie":            {"a=1", "b=2"},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("copyReturnHeaders() = %v, want %v", h, want)
+	}
+}
+
+func TestEngine_ForwardInvalidMetadata(t *testing.T) {
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"s": startNamedServer(t, "ok")})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.Forward(metadata.ForwardRule{Name: "X-User", Source: metadata.BindHeader})
+	e := builder.Build()
+	err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: mockServerCall("s")}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+	req.Header.Set("X-User", "José")
+	resp := &mockResponse{}
+	e.ServeHTTP(resp, req)
+	if resp.statusCode != 0 || string(resp.data) != "ok" {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+}
@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vizee/gapi/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type HealthState uint32
+
+const (
+	HealthUnknown HealthState = iota
+	HealthServing
+	HealthNotServing
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthServing:
+		return "serving"
+	case HealthNotServing:
+		return "not serving"
+	}
+	return "unknown"
+}
+
+type HealthCheckConfig struct {
+	Interval time.Duration
+	// Timeout 是单次检查的超时，默认与 Interval 相同
+	Timeout time.Duration
+	// Service 是检查的服务名，默认为空表示整个 server
+	Service string
+	// UnhealthyThreshold 是连续失败多少次后标记为不健康，默认 1
+	UnhealthyThreshold int
+}
+
+type serverHealth struct {
+	cc     *connPool
+	state  atomic.Uint32
+	cancel context.CancelFunc
+}
+
+// healthChecker 使用 grpc.health.v1 定期检查 Engine 中的每个 server
+type healthChecker struct {
+	cfg     HealthCheckConfig
+	mu      sync.Mutex
+	servers atomic.Pointer[map[string]*serverHealth]
+}
+
+func newHealthChecker(cfg HealthCheckConfig) *healthChecker {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval
+	}
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = 1
+	}
+	return &healthChecker{cfg: cfg}
+}
+
+// sync 为 clients 中新的连接启动检查，并停止不再使用的连接的检查
+func (h *healthChecker) sync(clients map[string]*connPool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var old map[string]*serverHealth
+	if p := h.servers.Load(); p != nil {
+		old = *p
+	}
+	servers := make(map[string]*serverHealth, len(clients))
+	for server, cc := range clients {
+		sh := old[server]
+		if sh == nil || sh.cc != cc {
+			ctx, cancel := context.WithCancel(context.Background())
+			sh = &serverHealth{cc: cc, cancel: cancel}
+			go h.probe(ctx, server, sh)
+		}
+		servers[server] = sh
+	}
+	for server, sh := range old {
+		if servers[server] != sh {
+			sh.cancel()
+		}
+	}
+	h.servers.Store(&servers)
+}
+
+func (h *healthChecker) state(server string) HealthState {
+	p := h.servers.Load()
+	if p == nil {
+		return HealthUnknown
+	}
+	sh := (*p)[server]
+	if sh == nil {
+		return HealthUnknown
+	}
+	return HealthState(sh.state.Load())
+}
+
+func (h *healthChecker) check(ctx context.Context, client healthpb.HealthClient) bool {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: h.cfg.Service})
+	if err != nil {
+		// 没有实现健康检查的 server 视为健康，能返回 Unimplemented 说明连接是可用的
+		return status.Code(err) == codes.Unimplemented
+	}
+	return resp.Status == healthpb.HealthCheckResponse_SERVING
+}
+
+func (h *healthChecker) probe(ctx context.Context, server string, sh *serverHealth) {
+	// 同一个 server 的连接指向相同的地址，只检查第一个连接
+	client := healthpb.NewHealthClient(sh.cc.conns[0])
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		ok := h.check(ctx, client)
+		if ctx.Err() != nil {
+			return
+		}
+		state := HealthServing
+		if ok {
+			failures = 0
+		} else {
+			failures++
+			if failures < h.cfg.UnhealthyThreshold {
+				state = HealthState(sh.state.Load())
+			} else {
+				state = HealthNotServing
+			}
+		}
+		if prev := HealthState(sh.state.Swap(uint32(state))); prev != state {
+			log.Warnf("Server %s health: %s", server, state)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServerHealth 返回每个 server 的健康状态，未开启健康检查时返回 nil
+func (e *Engine) ServerHealth() map[string]HealthState {
+	if e.health == nil {
+		return nil
+	}
+	p := e.health.servers.Load()
+	if p == nil {
+		return nil
+	}
+	states := make(map[string]HealthState, len(*p))
+	for server, sh := range *p {
+		states[server] = HealthState(sh.state.Load())
+	}
+	return states
+}
+
+// healthy 在未开启健康检查或者还没有检查结果时总是返回 true
+func (e *Engine) healthy(server string) bool {
+	return e.health == nil || e.health.state(server) != HealthNotServing
+}
+
+// conn 选择本次调用使用的连接，Call.Server 不健康或者熔断时改用 Call.Fallback，都不可用时快速失败
+func (r *grpcRoute) conn() (*grpc.ClientConn, admission, error) {
+	var err error
+	for _, t := range [2]struct {
+		server string
+		cc     *connPool
+	}{{r.call.Server, r.client}, {r.call.Fallback, r.fallback}} {
+		if t.cc == nil {
+			continue
+		}
+		if !r.engine.healthy(t.server) {
+			if err == nil {
+				err = status.Errorf(codes.Unavailable, "server %s is unhealthy", t.server)
+			}
+			continue
+		}
+		adm, ok := r.engine.admit(t.server)
+		if !ok {
+			if err == nil {
+				err = status.Errorf(codes.Unavailable, "circuit breaker of server %s is open", t.server)
+			}
+			continue
+		}
+		return t.cc.pick(), adm, nil
+	}
+	return nil, admission{}, err
+}
@@ -0,0 +1,141 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// mixedCodec 让 health 服务使用 proto 编码，其他方法使用原始字节
+type mixedCodec struct {
+	passthroughCodec
+}
+
+func (c *mixedCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	return c.passthroughCodec.Marshal(v)
+}
+
+func (c *mixedCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	return c.passthroughCodec.Unmarshal(data, v)
+}
+
+// startHealthServer 启动一个带有 grpc.health.v1 服务的 server，其他方法都返回 name
+func startHealthServer(t *testing.T, name string) (*grpc.ClientConn, *health.Server) {
+	return startHealthHandler(t, func(_ any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg([]byte(name))
+	})
+}
+
+// startHealthHandler 启动一个带有 grpc.health.v1 服务的 server，其他方法由 handler 处理
+func startHealthHandler(t *testing.T, handler grpc.StreamHandler) (*grpc.ClientConn, *health.Server) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnknownServiceHandler(handler), grpc.ForceServerCodec(&mixedCodec{}))
+	hs := health.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Stop()
+	})
+	return cc, hs
+}
+
+func waitHealth(t *testing.T, e *Engine, server string, state HealthState) {
+	deadline := time.Now().Add(5 * time.Second)
+	for e.ServerHealth()[server] != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s health: %s", server, e.ServerHealth()[server])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEngine_HealthCheck(t *testing.T) {
+	primary, primaryHealth := startHealthServer(t, "primary")
+	backup, backupHealth := startHealthServer(t, "backup")
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"primary": primary, "backup": backup})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.HealthCheck(HealthCheckConfig{Interval: 10 * time.Millisecond})
+	e := builder.Build()
+	defer e.ClearRouter()
+
+	withFallback := mockServerCall("primary")
+	withFallback.Fallback = "backup"
+	err := e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: withFallback},
+		{Method: "POST", Path: "/sub", Call: mockServerCall("primary")},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path string) *mockResponse {
+		req, _ := http.NewRequest("POST", "http://localhost"+path, strings.NewReader(`{}`))
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		return resp
+	}
+
+	waitHealth(t, e, "primary", HealthServing)
+	waitHealth(t, e, "backup", HealthServing)
+	if resp := serve("/add"); string(resp.data) != "primary" {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+
+	primaryHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	waitHealth(t, e, "primary", HealthNotServing)
+	if resp := serve("/add"); string(resp.data) != "backup" {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+	if resp := serve("/sub"); resp.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+
+	backupHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	waitHealth(t, e, "backup", HealthNotServing)
+	if resp := serve("/add"); resp.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+
+	primaryHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	waitHealth(t, e, "primary", HealthServing)
+	if resp := serve("/sub"); string(resp.data) != "primary" {
+		t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+	}
+
+	e.ClearRouter()
+	if states := e.ServerHealth(); len(states) != 0 {
+		t.Fatalf("health: %v", states)
+	}
+}
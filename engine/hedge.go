@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type HedgeStats struct {
+	// Requests 是使用 hedging 的请求数
+	Requests uint64
+	// Hedges 是发出的额外调用数
+	Hedges uint64
+	// Wins 是由额外调用返回结果的请求数
+	Wins uint64
+}
+
+type hedgeCounters struct {
+	requests atomic.Uint64
+	hedges   atomic.Uint64
+	wins     atomic.Uint64
+}
+
+func (e *Engine) HedgeStats() HedgeStats {
+	return HedgeStats{
+		Requests: e.hedgeStats.requests.Load(),
+		Hedges:   e.hedgeStats.hedges.Load(),
+		Wins:     e.hedgeStats.wins.Load(),
+	}
+}
+
+type hedgeResult struct {
+	index   int
+	data    []byte
+	header  grpcmd.MD
+	trailer grpcmd.MD
+	err     error
+}
+
+// hedgeConn 选择额外调用使用的连接，Hedge.Server 和主调用一样需要健康并且通过熔断器，否则改用主调用的连接
+func (r *grpcRoute) hedgeConn(cc *grpc.ClientConn) (*grpc.ClientConn, admission) {
+	if r.hedge == nil || !r.engine.healthy(r.call.Hedge.Server) {
+		return cc, admission{}
+	}
+	adm, ok := r.engine.admit(r.call.Hedge.Server)
+	if !ok {
+		return cc, admission{}
+	}
+	return r.hedge.pick(), adm
+}
+
+// invokeHedged 先发出一次调用，每隔 Delay 没有结果时再发出一次，返回最先成功的结果并取消其他调用。
+// Unavailable 的失败会立即触发下一次调用，其他错误直接返回
+func (r *grpcRoute) invokeHedged(ctx *Context, callctx context.Context, cc *grpc.ClientConn, reqData []byte) ([]byte, error) {
+	policy := r.call.Hedge
+	attempts := policy.MaxAttempts
+	if attempts < 2 {
+		attempts = 2
+	}
+	hedgectx, cancel := context.WithCancel(callctx)
+	defer cancel()
+	// finished 在取消其他调用前设置，被取消的调用不计入熔断统计。
+	// 调用可能在返回后才结束，此时 ctx 已经被回收，需要提前取出
+	var finished atomic.Bool
+	defer finished.Store(true)
+	reqctx, clientDeadline := ctx.req.Context(), ctx.clientDeadline
+
+	counters := &r.engine.hedgeStats
+	counters.requests.Add(1)
+	results := make(chan *hedgeResult, attempts)
+	launched := 0
+	launch := func() {
+		res := &hedgeResult{index: launched}
+		conn := cc
+		var adm admission
+		if launched > 0 {
+			counters.hedges.Add(1)
+			conn, adm = r.hedgeConn(cc)
+		}
+		launched++
+		go func() {
+			res.err = conn.Invoke(hedgectx, r.call.Method, reqData, &res.data, grpc.ForceCodec(&passthroughCodec{}), grpc.Header(&res.header), grpc.Trailer(&res.trailer))
+			if res.err != nil && (finished.Load() || clientFault(reqctx, clientDeadline, res.err)) {
+				adm.cancel()
+			} else {
+				adm.done(res.err)
+			}
+			results <- res
+		}()
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(policy.Delay)
+	defer timer.Stop()
+	var last *hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if launched < attempts {
+				launch()
+				pending++
+				timer.Reset(policy.Delay)
+			}
+		case res := <-results:
+			pending--
+			last = res
+			if res.err == nil {
+				if res.index > 0 {
+					counters.wins.Add(1)
+				}
+				ctx.header, ctx.trailer = res.header, res.trailer
+				return res.data, nil
+			}
+			if status.Code(res.err) != codes.Unavailable {
+				ctx.header, ctx.trailer = res.header, res.trailer
+				return nil, res.err
+			}
+			if pending == 0 && launched < attempts && callctx.Err() == nil {
+				launch()
+				pending++
+			}
+		}
+	}
+	ctx.header, ctx.trailer = last.header, last.trailer
+	return nil, last.err
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcRoute_Hedge(t *testing.T) {
+	const (
+		modeSlow int32 = iota
+		modeFast
+		modeUnavailable
+		modeInvalid
+	)
+	var mode atomic.Int32
+	primary := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		switch mode.Load() {
+		case modeSlow:
+			select {
+			case <-time.After(time.Second):
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			}
+		case modeUnavailable:
+			return status.Error(codes.Unavailable, "unavailable")
+		case modeInvalid:
+			return status.Error(codes.InvalidArgument, "invalid")
+		}
+		return stream.SendMsg([]byte("primary"))
+	})
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"primary": primary, "secondary": startNamedServer(t, "secondary")})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	e := builder.Build()
+
+	tests := []struct {
+		name       string
+		mode       int32
+		idempotent bool
+		status     int
+		data       string
+		stats      HedgeStats
+	}{
+		{name: "hedge_wins", mode: modeSlow, idempotent: true, data: "secondary", stats: HedgeStats{Requests: 1, Hedges: 1, Wins: 1}},
+		{name: "primary_fast", mode: modeFast, idempotent: true, data: "primary", stats: HedgeStats{Requests: 1}},
+		{name: "unavailable", mode: modeUnavailable, idempotent: true, data: "secondary", stats: HedgeStats{Requests: 1, Hedges: 1, Wins: 1}},
+		{name: "fatal", mode: modeInvalid, idempotent: true, status: http.StatusBadRequest, stats: HedgeStats{Requests: 1}},
+		{name: "not_idempotent", mode: modeFast, data: "primary"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode.Store(tt.mode)
+			call := mockServerCall("primary")
+			call.Idempotent = tt.idempotent
+			call.Hedge = &metadata.HedgePolicy{Delay: 50 * time.Millisecond, Server: "secondary"}
+			err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: call}}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := e.HedgeStats()
+			req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+			resp := &mockResponse{}
+			e.ServeHTTP(resp, req)
+			if resp.statusCode != tt.status || (tt.status == 0 && string(resp.data) != tt.data) {
+				t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+			}
+			after := e.HedgeStats()
+			got := HedgeStats{Requests: after.Requests - before.Requests, Hedges: after.Hedges - before.Hedges, Wins: after.Wins - before.Wins}
+			if got != tt.stats {
+				t.Fatalf("stats: %+v", got)
+			}
+		})
+	}
+}
+
+// slowHandler 延迟 100ms 后返回 name
+func slowHandler(name string) grpc.StreamHandler {
+	return func(_ any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		return stream.SendMsg([]byte(name))
+	}
+}
+
+// TestGrpcRoute_HedgeAdmission 检查额外调用和主调用一样经过健康检查和熔断器
+func TestGrpcRoute_HedgeAdmission(t *testing.T) {
+	serve := func(e *Engine) *mockResponse {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		return resp
+	}
+	hedgedCall := func() *metadata.Call {
+		call := mockServerCall("primary")
+		call.Idempotent = true
+		call.Hedge = &metadata.HedgePolicy{Delay: 10 * time.Millisecond, MaxAttempts: 3, Server: "secondary"}
+		return call
+	}
+
+	t.Run("breaker", func(t *testing.T) {
+		var calls atomic.Int32
+		secondary := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+			calls.Add(1)
+			return status.Error(codes.Unavailable, "unavailable")
+		})
+		builder := NewBuilder()
+		builder.Dialer(connDialer{"primary": startMockServer(t, slowHandler("primary")), "secondary": secondary})
+		builder.RegisterHandler("mock-handler", &mockHandler{})
+		builder.CircuitBreaker(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Minute})
+		e := builder.Build()
+		defer e.ClearRouter()
+		err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: hedgedCall()}}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if resp := serve(e); string(resp.data) != "primary" {
+				t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+			}
+		}
+		if n := calls.Load(); n != 1 {
+			t.Fatalf("secondary calls: %d", n)
+		}
+		if s := e.Breakers()["secondary"]; s.State != BreakerOpen {
+			t.Fatalf("breaker: %+v", s)
+		}
+		if s := e.Breakers()["primary"]; s.State != BreakerClosed {
+			t.Fatalf("breaker: %+v", s)
+		}
+	})
+
+	t.Run("health", func(t *testing.T) {
+		primary, _ := startHealthHandler(t, slowHandler("primary"))
+		secondary, secondaryHealth := startHealthServer(t, "secondary")
+		builder := NewBuilder()
+		builder.Dialer(connDialer{"primary": primary, "secondary": secondary})
+		builder.RegisterHandler("mock-handler", &mockHandler{})
+		builder.HealthCheck(HealthCheckConfig{Interval: 10 * time.Millisecond})
+		e := builder.Build()
+		defer e.ClearRouter()
+		err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: hedgedCall()}}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitHealth(t, e, "primary", HealthServing)
+		waitHealth(t, e, "secondary", HealthServing)
+		if resp := serve(e); string(resp.data) != "secondary" {
+			t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+		}
+		secondaryHealth.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		waitHealth(t, e, "secondary", HealthNotServing)
+		if resp := serve(e); string(resp.data) != "primary" {
+			t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+		}
+	})
+}
@@ -1,11 +1,18 @@
 package engine
 
 import (
+	"bytes"
+	"context"
 	"io"
+	"net"
 	"net/http"
+	"testing"
 
 	"github.com/vizee/gapi/metadata"
 	"github.com/vizee/jsonpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 type mockHandler struct{}
@@ -19,6 +26,93 @@ func (*mockHandler) WriteResponse(call *metadata.Call, ctx *Context, data []byte
 	return err
 }
 
+type mockStreamHandler struct {
+	mockHandler
+	began bool
+	ended error
+}
+
+func (h *mockStreamHandler) BeginStream(_ *metadata.Call, ctx *Context) error {
+	h.began = true
+	return nil
+}
+
+func (*mockStreamHandler) WriteMessage(_ *metadata.Call, ctx *Context, data []byte) error {
+	_, err := ctx.Response().Write(append(data, '\n'))
+	return err
+}
+
+func (h *mockStreamHandler) EndStream(_ *metadata.Call, ctx *Context, err error) error {
+	h.ended = err
+	return nil
+}
+
+type mockClientStreamHandler struct {
+	mockHandler
+}
+
+func (*mockClientStreamHandler) ReadStream(_ *metadata.Call, ctx *Context, send func(data []byte) error) error {
+	data, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return err
+	}
+	for _, s := range bytes.Split(data, []byte{','}) {
+		err = send(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type mockBidiStreamHandler struct {
+	mockHandler
+}
+
+// ServeStream 把请求体按 , 拆分发送，并把收到的消息按行输出
+func (*mockBidiStreamHandler) ServeStream(_ *metadata.Call, ctx *Context, stream grpc.ClientStream) error {
+	data, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return err
+	}
+	for _, s := range bytes.Split(data, []byte{','}) {
+		err = stream.SendMsg(s)
+		if err != nil {
+			return err
+		}
+		var resp []byte
+		err = stream.RecvMsg(&resp)
+		if err != nil {
+			return err
+		}
+		_, err = ctx.Response().Write(append(resp, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return stream.CloseSend()
+}
+
+// startMockServer 启动一个内存中的 GRPC 服务，所有方法都由 handler 以原始字节处理
+func startMockServer(t *testing.T, handler grpc.StreamHandler) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(&passthroughCodec{}), grpc.UnknownServiceHandler(handler))
+	go srv.Serve(lis)
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Stop()
+	})
+	return cc
+}
+
 type mockResponse struct {
 	statusCode int
 	header     http.Header
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// connPool 是同一个 server 的一组连接，调用轮流使用其中的连接，避免单个 HTTP/2 连接的并发流限制成为瓶颈
+type connPool struct {
+	conns []*grpc.ClientConn
+	next  atomic.Uint32
+}
+
+func (p *connPool) pick() *grpc.ClientConn {
+	if len(p.conns) == 1 {
+		return p.conns[0]
+	}
+	return p.conns[(p.next.Add(1)-1)%uint32(len(p.conns))]
+}
+
+func (p *connPool) Close() {
+	for _, cc := range p.conns {
+		cc.Close()
+	}
+}
+
+// dialPool 为 server 建立 Builder.ConnPool 指定数量的连接，任意一个连接失败时关闭已经建立的连接
+func (e *Engine) dialPool(ctx context.Context, server string) (*connPool, error) {
+	size := e.poolSize
+	if size < 1 {
+		size = 1
+	}
+	pool := &connPool{conns: make([]*grpc.ClientConn, 0, size)}
+	for i := 0; i < size; i++ {
+		cc, err := e.dial(ctx, server)
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, cc)
+	}
+	return pool, nil
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// poolDialer 每次建立连接都启动一个新的 server，server 返回自己的编号
+type poolDialer struct {
+	t     *testing.T
+	conns []*grpc.ClientConn
+}
+
+func (d *poolDialer) Dial(server string) (*grpc.ClientConn, error) {
+	cc := startNamedServer(d.t, fmt.Sprintf("%s#%d", server, len(d.conns)))
+	d.conns = append(d.conns, cc)
+	return cc, nil
+}
+
+func TestEngine_ConnPool(t *testing.T) {
+	d := &poolDialer{t: t}
+	builder := NewBuilder()
+	builder.Dialer(d)
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.ConnPool(3)
+	e := builder.Build()
+
+	routes := []*metadata.Route{{Method: "POST", Path: "/add", Call: mockServerCall("s")}}
+	for i := 0; i < 2; i++ {
+		err := e.RebuildRouter(routes, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.conns) != 3 {
+		t.Fatalf("dialed %d connections", len(d.conns))
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		counts[string(resp.data)]++
+	}
+	if len(counts) != 3 || counts["s#0"] != 2 || counts["s#1"] != 2 || counts["s#2"] != 2 {
+		t.Fatalf("counts: %v", counts)
+	}
+
+	e.ClearRouter()
+	for _, cc := range d.conns {
+		if cc.GetState() != connectivity.Shutdown {
+			t.Fatalf("connection not closed: %v", cc.GetState())
+		}
+	}
+}
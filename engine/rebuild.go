@@ -0,0 +1,272 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vizee/gapi/metadata"
+)
+
+type RouteResult struct {
+	Index  int
+	Method string
+	Path   string
+	Server string
+	// Err 是路由被拒绝的原因，接受的路由为 nil
+	Err error
+}
+
+type RebuildResult struct {
+	// Generation 是新 router 的编号，失败时为 0
+	Generation uint64
+	Accepted   []RouteResult
+	Rejected   []RouteResult
+	// Dialed 是新建立连接的 server
+	Dialed []string
+	// Reused 是复用上一次连接的 server
+	Reused []string
+	// Closed 是不再被引用的 server，连接在旧 router 上的请求结束后关闭
+	Closed []string
+}
+
+func newRouteResult(index int, route *metadata.Route, err error) RouteResult {
+	rr := RouteResult{
+		Index:  index,
+		Method: route.Method,
+		Path:   route.Path,
+		Err:    err,
+	}
+	if route.Call != nil {
+		rr.Server = route.Call.Server
+	}
+	return rr
+}
+
+func (r *RebuildResult) accept(index int, route *metadata.Route) {
+	r.Accepted = append(r.Accepted, newRouteResult(index, route, nil))
+}
+
+func (r *RebuildResult) reject(index int, route *metadata.Route, err error) {
+	r.Rejected = append(r.Rejected, newRouteResult(index, route, err))
+}
+
+func (r *RebuildResult) sort() {
+	sort.Strings(r.Dialed)
+	sort.Strings(r.Reused)
+	sort.Strings(r.Closed)
+}
+
+// rebuilder 保存一次 router 构建过程中的连接和缓存
+type rebuilder struct {
+	engine     *Engine
+	old        map[string]*connPool
+	clients    map[string]*connPool
+	chainCache map[string][]HandleFunc
+	result     *RebuildResult
+	// dialed 是并发建立的连接，为 nil 时在 client 中同步建立连接
+	dialed map[string]dialResult
+}
+
+type dialResult struct {
+	cc  *connPool
+	err error
+}
+
+func collectRoutes[R RouteIter](routeIter R) []*metadata.Route {
+	var routes []*metadata.Route
+	for {
+		route := routeIter.NextRoute()
+		if route == nil {
+			return routes
+		}
+		routes = append(routes, route)
+	}
+}
+
+func callServers(call *metadata.Call, fn func(server string)) {
+	if call == nil {
+		return
+	}
+	fn(call.Server)
+	if call.Fallback != "" {
+		fn(call.Fallback)
+	}
+	if call.Hedge != nil && call.Hedge.Server != "" {
+		fn(call.Hedge.Server)
+	}
+}
+
+// dialAll 并发地为 routes 中没有旧连接的 server 建立连接，最多等待 timeout，
+// 超时的 server 记为失败并取消连接，Dialer 不支持取消时之后完成的连接会被关闭
+func (b *rebuilder) dialAll(routes []*metadata.Route, timeout time.Duration) {
+	servers := make(map[string]bool)
+	add := func(server string) {
+		if b.old[server] == nil {
+			servers[server] = true
+		}
+	}
+	for _, route := range routes {
+		callServers(route.Call, add)
+		if route.Split != nil {
+			for i := range route.Split.Targets {
+				callServers(route.Split.Targets[i].Call, add)
+			}
+		}
+	}
+
+	type result struct {
+		server string
+		dialResult
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results := make(chan result, len(servers))
+	for server := range servers {
+		go func(server string) {
+			cc, err := b.engine.dialPool(ctx, server)
+			results <- result{server: server, dialResult: dialResult{cc: cc, err: err}}
+		}(server)
+	}
+
+	b.dialed = make(map[string]dialResult, len(servers))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for pending := len(servers); pending > 0; pending-- {
+		select {
+		case res := <-results:
+			b.dialed[res.server] = res.dialResult
+		case <-timer.C:
+			for server := range servers {
+				if _, ok := b.dialed[server]; !ok {
+					b.dialed[server] = dialResult{err: fmt.Errorf("timeout after %v", timeout)}
+				}
+			}
+			go func(pending int) {
+				for ; pending > 0; pending-- {
+					res := <-results
+					if res.cc != nil {
+						res.cc.Close()
+					}
+				}
+			}(pending)
+			return
+		}
+	}
+}
+
+// closeUnused 关闭并发建立但没有被任何路由使用的连接
+func (b *rebuilder) closeUnused() {
+	for server, res := range b.dialed {
+		if res.cc != nil && b.clients[server] != res.cc && b.engine.clients[server] != res.cc {
+			res.cc.Close()
+		}
+	}
+}
+
+// client 建立连接，尽可能复用旧连接
+func (b *rebuilder) client(server string) (*connPool, error) {
+	client := b.clients[server]
+	if client != nil {
+		return client, nil
+	}
+	client = b.old[server]
+	if client == nil {
+		var err error
+		if res, ok := b.dialed[server]; ok {
+			client, err = res.cc, res.err
+		} else {
+			client, err = b.engine.dialPool(context.Background(), server)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dial %s: %w", server, err)
+		}
+		b.result.Dialed = append(b.result.Dialed, server)
+	} else {
+		b.result.Reused = append(b.result.Reused, server)
+	}
+	b.clients[server] = client
+	return client, nil
+}
+
+func (b *rebuilder) callRoute(path string, call *metadata.Call, middlewares []HandleFunc) (*grpcRoute, error) {
+	e := b.engine
+	ch := e.handlers[call.Handler]
+	if ch == nil {
+		return nil, fmt.Errorf("route %s handler %s not found", path, call.Handler)
+	}
+	if !supportStream(ch, call.Stream) {
+		return nil, fmt.Errorf("route %s handler %s does not support stream type %d", path, call.Handler, call.Stream)
+	}
+	client, err := b.client(call.Server)
+	if err != nil {
+		return nil, err
+	}
+	var fallback *connPool
+	if call.Fallback != "" {
+		fallback, err = b.client(call.Fallback)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var hedge *connPool
+	if call.Hedge != nil && call.Hedge.Server != "" {
+		hedge, err = b.client(call.Hedge.Server)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &grpcRoute{
+		engine:      e,
+		middlewares: middlewares,
+		call:        call,
+		ch:          ch,
+		client:      client,
+		fallback:    fallback,
+		hedge:       hedge,
+		onError:     e.routeErrorHandler(call, ch),
+	}, nil
+}
+
+func (b *rebuilder) routeHandle(route *metadata.Route) (httprouter.Handle, error) {
+	if route.Call == nil {
+		return nil, fmt.Errorf("route %s call not specified", route.Path)
+	}
+	middlewares, err := b.engine.generateMiddlewareChain(b.chainCache, route.Use)
+	if err != nil {
+		return nil, fmt.Errorf("middleware of %s: %v", route.Path, err)
+	}
+	gr, err := b.callRoute(route.Path, route.Call, middlewares)
+	if err != nil {
+		return nil, err
+	}
+	if route.Split == nil {
+		return gr.handleRoute, nil
+	}
+
+	sr := &splitRoute{
+		header:  route.Split.Header,
+		cookie:  route.Split.Cookie,
+		targets: []splitTarget{{route: gr, weight: route.Split.Weight, pin: route.Split.Pin}},
+		total:   route.Split.Weight,
+	}
+	for i := range route.Split.Targets {
+		t := &route.Split.Targets[i]
+		if t.Call == nil {
+			return nil, fmt.Errorf("route %s split target #%d call not specified", route.Path, i)
+		}
+		tr, err := b.callRoute(route.Path, t.Call, middlewares)
+		if err != nil {
+			return nil, err
+		}
+		sr.targets = append(sr.targets, splitTarget{route: tr, weight: t.Weight, pin: t.Pin})
+		sr.total += t.Weight
+	}
+	if sr.total == 0 {
+		return nil, errors.New("route " + route.Path + " split has no weight")
+	}
+	return sr.handleRoute, nil
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type mockDialer struct{}
+
+func (mockDialer) Dial(server string) (*grpc.ClientConn, error) {
+	if server == "bad" {
+		return nil, errors.New("bad server")
+	}
+	return grpc.Dial(server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+func mockServerCall(server string) *metadata.Call {
+	call := mockAddCall()
+	call.Server = server
+	call.Method = "/pdtest.Calc/Add"
+	return call
+}
+
+func TestEngine_RebuildRouterResult(t *testing.T) {
+	builder := NewBuilder()
+	builder.Dialer(mockDialer{})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	e := builder.Build()
+	defer e.ClearRouter()
+
+	noHandler := mockAddCall()
+	noHandler.Handler = "unknown"
+	result, err := e.RebuildRouterResult([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("a:1")},
+		{Method: "POST", Path: "/unknown", Call: noHandler},
+		{Method: "POST", Path: "/bad", Call: mockServerCall("bad")},
+		{Method: "POST", Path: "/auth", Use: []string{"auth"}, Call: mockServerCall("a:1")},
+		{Method: "POST", Path: "/add", Call: mockServerCall("a:1")},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Accepted) != 1 || result.Accepted[0].Path != "/add" || result.Accepted[0].Server != "a:1" {
+		t.Fatalf("accepted: %+v", result.Accepted)
+	}
+	var rejected []int
+	for _, rr := range result.Rejected {
+		if rr.Err == nil {
+			t.Fatalf("rejected without reason: %+v", rr)
+		}
+		rejected = append(rejected, rr.Index)
+	}
+	if !reflect.DeepEqual(rejected, []int{1, 2, 3, 4}) {
+		t.Fatalf("rejected: %+v", result.Rejected)
+	}
+	if !reflect.DeepEqual(result.Dialed, []string{"a:1"}) || len(result.Reused) != 0 || len(result.Closed) != 0 {
+		t.Fatalf("servers: %+v", result)
+	}
+
+	result, err = e.RebuildRouterResult([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("b:1")},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Dialed, []string{"b:1"}) || !reflect.DeepEqual(result.Closed, []string{"a:1"}) {
+		t.Fatalf("servers: %+v", result)
+	}
+
+	router := e.gen.Load()
+	result, err = e.RebuildRouterResult([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("b:1")},
+		{Method: "POST", Path: "/sub", Call: mockServerCall("c:1")},
+		{Method: "POST", Path: "/bad", Call: mockServerCall("bad")},
+	}, false)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if e.gen.Load() != router {
+		t.Fatal("router replaced")
+	}
+	if len(result.Accepted) != 2 || len(result.Rejected) != 1 || result.Rejected[0].Err == nil {
+		t.Fatalf("routes: %+v", result)
+	}
+	if !reflect.DeepEqual(result.Reused, []string{"b:1"}) || !reflect.DeepEqual(result.Dialed, []string{"c:1"}) || !reflect.DeepEqual(result.Closed, []string{"c:1"}) {
+		t.Fatalf("servers: %+v", result)
+	}
+}
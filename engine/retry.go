@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func retriable(policy *metadata.RetryPolicy, code codes.Code) bool {
+	if len(policy.Codes) == 0 {
+		return code == codes.Unavailable
+	}
+	for _, c := range policy.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func nextBackoff(policy *metadata.RetryPolicy, backoff time.Duration) time.Duration {
+	mult := policy.BackoffMultiplier
+	if mult <= 0 {
+		mult = 2
+	}
+	backoff = time.Duration(float64(backoff) * mult)
+	if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	return backoff
+}
+
+// jitter 在 [0.8, 1.2) 范围内随机调整等待时间，避免同时失败的请求一起重试
+func jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (0.8 + rand.Float64()*0.4))
+}
+
+func (r *grpcRoute) invokeOnce(ctx *Context, callctx context.Context, cc *grpc.ClientConn, reqData []byte, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		callctx, cancel = context.WithTimeout(callctx, timeout)
+		defer cancel()
+	}
+	ctx.header, ctx.trailer = nil, nil
+	var respData []byte
+	err := cc.Invoke(callctx, r.call.Method, reqData, &respData, grpc.ForceCodec(&passthroughCodec{}), grpc.Header(&ctx.header), grpc.Trailer(&ctx.trailer))
+	return respData, err
+}
+
+// invoke 执行一元调用，按 Call.Hedge 或 Call.Retry 发出多次调用，所有尝试和等待都在 callctx 的期限内完成
+func (r *grpcRoute) invoke(ctx *Context, callctx context.Context, cc *grpc.ClientConn, reqData []byte) ([]byte, error) {
+	if r.call.Hedge != nil && r.call.Idempotent {
+		return r.invokeHedged(ctx, callctx, cc, reqData)
+	}
+	policy := r.call.Retry
+	if policy == nil || !r.call.Idempotent || policy.MaxAttempts <= 1 {
+		return r.invokeOnce(ctx, callctx, cc, reqData, 0)
+	}
+
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		respData, err := r.invokeOnce(ctx, callctx, cc, reqData, policy.PerTryTimeout)
+		if err == nil || attempt >= policy.MaxAttempts || callctx.Err() != nil {
+			return respData, err
+		}
+		code := status.Code(err)
+		// 单次超时在整体期限内可以重试
+		if !retriable(policy, code) && !(code == codes.DeadlineExceeded && policy.PerTryTimeout > 0) {
+			return respData, err
+		}
+
+		wait := jitter(backoff)
+		if deadline, ok := callctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return respData, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-callctx.Done():
+			timer.Stop()
+			return respData, err
+		case <-timer.C:
+		}
+		backoff = nextBackoff(policy, backoff)
+	}
+}
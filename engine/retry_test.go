@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcRoute_Retry(t *testing.T) {
+	var (
+		attempts atomic.Int32
+		failures atomic.Int32
+		failWith atomic.Uint32
+		delay    atomic.Int64
+	)
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		attempts.Add(1)
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		if failures.Add(-1) >= 0 {
+			if d := time.Duration(delay.Load()); d > 0 {
+				select {
+				case <-time.After(d):
+				case <-stream.Context().Done():
+				}
+				return stream.Context().Err()
+			}
+			return status.Error(codes.Code(failWith.Load()), "failed")
+		}
+		return stream.SendMsg([]byte("ok"))
+	})
+
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"mock": cc})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	e := builder.Build()
+
+	tests := []struct {
+		name       string
+		idempotent bool
+		timeout    time.Duration
+		policy     metadata.RetryPolicy
+		failures   int32
+		code       codes.Code
+		delay      time.Duration
+		attempts   int32
+		status     int
+	}{
+		{name: "success", idempotent: true, policy: metadata.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, failures: 2, code: codes.Unavailable, attempts: 3},
+		{name: "exhausted", idempotent: true, policy: metadata.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, failures: 5, code: codes.Unavailable, attempts: 3, status: http.StatusServiceUnavailable},
+		{name: "not_idempotent", policy: metadata.RetryPolicy{MaxAttempts: 3}, failures: 1, code: codes.Unavailable, attempts: 1, status: http.StatusServiceUnavailable},
+		{name: "not_retriable", idempotent: true, policy: metadata.RetryPolicy{MaxAttempts: 3}, failures: 1, code: codes.InvalidArgument, attempts: 1, status: http.StatusBadRequest},
+		{name: "custom_codes", idempotent: true, policy: metadata.RetryPolicy{MaxAttempts: 2, Codes: []codes.Code{codes.ResourceExhausted}}, failures: 1, code: codes.ResourceExhausted, attempts: 2},
+		{name: "budget", idempotent: true, timeout: 50 * time.Millisecond, policy: metadata.RetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond}, failures: 1, code: codes.Unavailable, attempts: 1, status: http.StatusServiceUnavailable},
+		{name: "per_try_timeout", idempotent: true, timeout: 5 * time.Second, policy: metadata.RetryPolicy{MaxAttempts: 2, PerTryTimeout: 20 * time.Millisecond}, failures: 1, delay: time.Second, attempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts.Store(0)
+			failures.Store(tt.failures)
+			failWith.Store(uint32(tt.code))
+			delay.Store(int64(tt.delay))
+
+			call := mockServerCall("mock")
+			call.Timeout = tt.timeout
+			call.Idempotent = tt.idempotent
+			call.Retry = &tt.policy
+			err := e.RebuildRouter([]*metadata.Route{{Method: "POST", Path: "/add", Call: call}}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+			resp := &mockResponse{}
+			e.ServeHTTP(resp, req)
+			if resp.statusCode != tt.status {
+				t.Fatalf("response %d: %s", resp.statusCode, resp.data)
+			}
+			if tt.status == 0 && string(resp.data) != "ok" {
+				t.Fatalf("response: %s", resp.data)
+			}
+			if n := attempts.Load(); n != tt.attempts {
+				t.Fatalf("attempts: %d", n)
+			}
+		})
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	policy := &metadata.RetryPolicy{MaxBackoff: 300 * time.Millisecond}
+	backoff := 100 * time.Millisecond
+	backoff = nextBackoff(policy, backoff)
+	if backoff != 200*time.Millisecond {
+		t.Fatalf("backoff: %v", backoff)
+	}
+	backoff = nextBackoff(policy, backoff)
+	if backoff != 300*time.Millisecond {
+		t.Fatalf("backoff: %v", backoff)
+	}
+	policy.BackoffMultiplier = 1.5
+	if backoff = nextBackoff(policy, 100*time.Millisecond); backoff != 150*time.Millisecond {
+		t.Fatalf("backoff: %v", backoff)
+	}
+}
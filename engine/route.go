@@ -7,6 +7,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/vizee/gapi/metadata"
 	"google.golang.org/grpc"
+	grpcmd "google.golang.org/grpc/metadata"
 )
 
 type passthroughCodec struct {
@@ -32,27 +33,75 @@ type grpcRoute struct {
 	middlewares []HandleFunc
 	call        *metadata.Call
 	ch          CallHandler
-	client      *grpc.ClientConn
+	client      *connPool
+	fallback    *connPool
+	hedge       *connPool
+	onError     ErrorHandler
+}
+
+func (r *grpcRoute) callContext(ctx *Context) (context.Context, context.CancelFunc, error) {
+	deadline, ok, err := r.deadline(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 之后的错误计入熔断统计
+	ctx.called = true
+	callctx := ctx.req.Context()
+	md := appendForwardMD(appendForwardMD(nil, ctx, r.engine.forwards), ctx, r.call.Forward)
+	if md != nil {
+		callctx = grpcmd.NewOutgoingContext(callctx, md)
+	}
+	if ok {
+		callctx, cancel := context.WithDeadline(callctx, deadline)
+		return callctx, cancel, nil
+	}
+	return callctx, nil, nil
 }
 
 func (r *grpcRoute) handle(ctx *Context) error {
+	cc, adm, err := r.conn()
+	if err != nil {
+		return err
+	}
+	err = r.handleCall(ctx, cc)
+	// 发出调用前的错误与 server 无关，例如读取请求失败或者期限已经用完，
+	// 客户端的期限用完或者客户端断开造成的错误同样不计入统计，避免客户端触发熔断
+	if ctx.called && !clientFault(ctx.req.Context(), ctx.clientDeadline, err) {
+		adm.done(err)
+	} else {
+		adm.cancel()
+	}
+	return err
+}
+
+func (r *grpcRoute) handleCall(ctx *Context, cc *grpc.ClientConn) error {
 	call := r.call
+	switch call.Stream {
+	case metadata.ServerStream:
+		return r.handleServerStream(ctx, cc)
+	case metadata.ClientStream:
+		return r.handleClientStream(ctx, cc)
+	case metadata.BidiStream:
+		return r.handleBidiStream(ctx, cc)
+	}
 
 	reqData, err := r.ch.ReadRequest(call, ctx)
 	if err != nil {
 		return err
 	}
 
-	callctx := ctx.req.Context()
-	var cancel func()
-	if call.Timeout > 0 {
-		callctx, cancel = context.WithTimeout(callctx, call.Timeout)
+	callctx, cancel, err := r.callContext(ctx)
+	if err != nil {
+		return err
 	}
-	var respData []byte
-	err = r.client.Invoke(callctx, call.Method, reqData, &respData, grpc.ForceCodec(&passthroughCodec{}))
+	respData, err := r.invoke(ctx, callctx, cc, reqData)
 	if cancel != nil {
 		cancel()
 	}
+	// 失败时同样写回，例如限流相关的头部
+	if len(call.Returns) > 0 {
+		copyReturnHeaders(ctx.resp.Header(), call.Returns, ctx.header, ctx.trailer)
+	}
 	if err != nil {
 		return err
 	}
@@ -62,5 +111,5 @@ func (r *grpcRoute) handle(ctx *Context) error {
 
 func (r *grpcRoute) handleRoute(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
 	// 封装闭包可能带来一点点内存开销
-	r.engine.Execute(w, req, Params(params), r.middlewares, r.handle)
+	r.engine.execute(w, req, Params(params), r.middlewares, r.handle, r.onError)
 }
@@ -14,12 +14,14 @@ func Test_grpcRoute_handleRoute(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	e := NewBuilder().Build()
 	gr := &grpcRoute{
-		engine:      NewBuilder().Build(),
+		engine:      e,
 		middlewares: []HandleFunc{},
 		call:        mockAddCall(),
 		ch:          &mockHandler{},
-		client:      cc,
+		client:      &connPool{conns: []*grpc.ClientConn{cc}},
+		onError:     e.onError,
 	}
 	req, err := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{"a":1,"b":2}`))
 	if err != nil {
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"math/rand"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type splitTarget struct {
+	route  *grpcRoute
+	weight uint32
+	pin    []string
+}
+
+// splitRoute 在多个调用目标之间分配请求，第一个目标是 Route.Call
+type splitRoute struct {
+	header  string
+	cookie  string
+	targets []splitTarget
+	total   uint32
+}
+
+func (r *splitRoute) pinValue(req *http.Request) string {
+	if r.header != "" {
+		v := req.Header.Get(r.header)
+		if v != "" {
+			return v
+		}
+	}
+	if r.cookie != "" {
+		c, err := req.Cookie(r.cookie)
+		if err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+func (r *splitRoute) pick(req *http.Request) *grpcRoute {
+	v := r.pinValue(req)
+	if v != "" {
+		for i := range r.targets {
+			for _, pin := range r.targets[i].pin {
+				if pin == v {
+					return r.targets[i].route
+				}
+			}
+		}
+	}
+	n := uint32(rand.Int63n(int64(r.total)))
+	for i := range r.targets {
+		if n < r.targets[i].weight {
+			return r.targets[i].route
+		}
+		n -= r.targets[i].weight
+	}
+	return r.targets[0].route
+}
+
+func (r *splitRoute) handleRoute(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	r.pick(req).handleRoute(w, req, params)
+}
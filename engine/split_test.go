@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+)
+
+func startNamedServer(t *testing.T, name string) *grpc.ClientConn {
+	return startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg([]byte(name))
+	})
+}
+
+func TestEngine_SplitRoute(t *testing.T) {
+	builder := NewBuilder()
+	builder.Dialer(connDialer{"v1": startNamedServer(t, "v1"), "v2": startNamedServer(t, "v2")})
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	e := builder.Build()
+
+	serve := func(prepare func(req *http.Request)) string {
+		req, _ := http.NewRequest("POST", "http://localhost/add", strings.NewReader(`{}`))
+		if prepare != nil {
+			prepare(req)
+		}
+		resp := &mockResponse{}
+		e.ServeHTTP(resp, req)
+		return string(resp.data)
+	}
+	rebuild := func(weight uint32, canary uint32) {
+		err := e.RebuildRouter([]*metadata.Route{
+			{Method: "POST", Path: "/add", Call: mockServerCall("v1"), Split: &metadata.Split{
+				Weight: weight,
+				Header: "X-Canary",
+				Cookie: "canary",
+				Targets: []metadata.SplitTarget{
+					{Call: mockServerCall("v2"), Weight: canary, Pin: []string{"yes"}},
+				},
+			}},
+		}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rebuild(1, 1)
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		counts[serve(nil)]++
+	}
+	if counts["v1"] == 0 || counts["v2"] == 0 || counts["v1"]+counts["v2"] != 200 {
+		t.Fatalf("counts: %v", counts)
+	}
+
+	rebuild(1, 0)
+	for i := 0; i < 20; i++ {
+		if resp := serve(nil); resp != "v1" {
+			t.Fatalf("unpinned: %s", resp)
+		}
+	}
+	if resp := serve(func(req *http.Request) { req.Header.Set("X-Canary", "yes") }); resp != "v2" {
+		t.Fatalf("header pinned: %s", resp)
+	}
+	if resp := serve(func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "canary", Value: "yes"}) }); resp != "v2" {
+		t.Fatalf("cookie pinned: %s", resp)
+	}
+	if resp := serve(func(req *http.Request) { req.Header.Set("X-Canary", "no") }); resp != "v1" {
+		t.Fatalf("unmatched pin: %s", resp)
+	}
+
+	// 灰度期间可以固定回到 Route.Call
+	err := e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("v1"), Split: &metadata.Split{
+			Weight: 0,
+			Pin:    []string{"stable"},
+			Header: "X-Canary",
+			Targets: []metadata.SplitTarget{
+				{Call: mockServerCall("v2"), Weight: 1},
+			},
+		}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := serve(nil); resp != "v2" {
+		t.Fatalf("unpinned: %s", resp)
+	}
+	if resp := serve(func(req *http.Request) { req.Header.Set("X-Canary", "stable") }); resp != "v1" {
+		t.Fatalf("primary pinned: %s", resp)
+	}
+
+	err = e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/add", Call: mockServerCall("v1"), Split: &metadata.Split{
+			Targets: []metadata.SplitTarget{{Call: mockServerCall("v2")}},
+		}},
+	}, false)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var defaultStatusMapping = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+func copyStatusMapping(m map[codes.Code]int) map[codes.Code]int {
+	c := make(map[codes.Code]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// HTTPStatus 返回 err 对应的 HTTP 状态码，不是 GRPC 状态的错误统一视为 500
+func (e *Engine) HTTPStatus(err error) int {
+	st, ok := status.FromError(err)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	code, ok := e.statusMapping[st.Code()]
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	return code
+}
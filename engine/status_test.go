@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEngine_HTTPStatus(t *testing.T) {
+	builder := NewBuilder()
+	builder.MapStatus(codes.FailedPrecondition, http.StatusPreconditionFailed)
+	e := builder.Build()
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "plain", err: errors.New("error"), want: http.StatusInternalServerError},
+		{name: "not_found", err: status.Error(codes.NotFound, "not found"), want: http.StatusNotFound},
+		{name: "invalid_argument", err: status.Error(codes.InvalidArgument, "bad"), want: http.StatusBadRequest},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "unavailable"), want: http.StatusServiceUnavailable},
+		{name: "wrapped", err: fmt.Errorf("invoke: %w", status.Error(codes.Unauthenticated, "")), want: http.StatusUnauthorized},
+		{name: "override", err: status.Error(codes.FailedPrecondition, ""), want: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.HTTPStatus(tt.err); got != tt.want {
+				t.Errorf("Engine.HTTPStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_Execute_status(t *testing.T) {
+	e := NewBuilder().Build()
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &mockResponse{}
+	e.Execute(resp, req, nil, nil, func(ctx *Context) error {
+		return status.Error(codes.PermissionDenied, "denied")
+	})
+	if resp.statusCode != http.StatusForbidden {
+		t.Fatalf("status %d", resp.statusCode)
+	}
+}
@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+)
+
+// ServerStreamHandler 用于 metadata.ServerStream 调用。
+// BeginStream 在收到第一条消息后调用，此后的错误只能通过 EndStream 输出，
+// EndStream 输出错误后仍然需要返回错误，返回的错误以 StreamError 交给错误处理
+type ServerStreamHandler interface {
+	CallHandler
+	BeginStream(call *metadata.Call, ctx *Context) error
+	WriteMessage(call *metadata.Call, ctx *Context, data []byte) error
+	EndStream(call *metadata.Call, ctx *Context, err error) error
+}
+
+// ClientStreamHandler 用于 metadata.ClientStream 调用。
+// ReadStream 从请求中逐条读取消息并交给 send 发送，最终的响应仍然由 WriteResponse 输出
+type ClientStreamHandler interface {
+	CallHandler
+	ReadStream(call *metadata.Call, ctx *Context, send func(data []byte) error) error
+}
+
+// BidiStreamHandler 用于 metadata.BidiStream 调用，ServeStream 负责在客户端和 stream 之间转发消息，
+// stream 已经使用 passthrough 编码，收发的消息都是 []byte。ServeStream 返回后 stream 会被取消
+type BidiStreamHandler interface {
+	CallHandler
+	ServeStream(call *metadata.Call, ctx *Context, stream grpc.ClientStream) error
+}
+
+// StreamError 是流式响应开始后发生的错误，错误已经写入响应，ErrorHandler 和 ErrorWriter 不应再输出响应
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+func endStream(sh ServerStreamHandler, call *metadata.Call, ctx *Context, err error) error {
+	err = sh.EndStream(call, ctx, err)
+	if err != nil {
+		return &StreamError{Err: err}
+	}
+	return nil
+}
+
+var (
+	serverStreamDesc = grpc.StreamDesc{
+		ServerStreams: true,
+	}
+	clientStreamDesc = grpc.StreamDesc{
+		ClientStreams: true,
+	}
+	bidiStreamDesc = grpc.StreamDesc{
+		ServerStreams: true,
+		ClientStreams: true,
+	}
+)
+
+// streamContext 总是返回 cancel，确保提前返回时流能被终止
+func (r *grpcRoute) streamContext(ctx *Context) (context.Context, context.CancelFunc, error) {
+	callctx, cancel, err := r.callContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cancel == nil {
+		callctx, cancel = context.WithCancel(callctx)
+	}
+	return callctx, cancel, nil
+}
+
+func (r *grpcRoute) handleServerStream(ctx *Context, cc *grpc.ClientConn) error {
+	call := r.call
+	sh := r.ch.(ServerStreamHandler)
+
+	reqData, err := sh.ReadRequest(call, ctx)
+	if err != nil {
+		return err
+	}
+
+	callctx, cancel, err := r.streamContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	stream, err := cc.NewStream(callctx, &serverStreamDesc, call.Method, grpc.ForceCodec(&passthroughCodec{}))
+	if err != nil {
+		return err
+	}
+	err = stream.SendMsg(reqData)
+	if err != nil {
+		return err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return err
+	}
+
+	// 第一条消息到达前的错误仍然按普通请求处理
+	var data []byte
+	err = stream.RecvMsg(&data)
+	ctx.header, _ = stream.Header()
+	if err != nil && err != io.EOF {
+		ctx.trailer = stream.Trailer()
+		if len(call.Returns) > 0 {
+			copyReturnHeaders(ctx.resp.Header(), call.Returns, ctx.header, ctx.trailer)
+		}
+		return err
+	}
+	if len(call.Returns) > 0 {
+		copyReturnHeaders(ctx.resp.Header(), call.Returns, ctx.header)
+	}
+
+	more := err == nil
+	err = sh.BeginStream(call, ctx)
+	if err != nil {
+		return err
+	}
+	for more {
+		err = sh.WriteMessage(call, ctx, data)
+		if err != nil {
+			return endStream(sh, call, ctx, err)
+		}
+		err = stream.RecvMsg(&data)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			ctx.trailer = stream.Trailer()
+			return endStream(sh, call, ctx, err)
+		}
+	}
+	ctx.trailer = stream.Trailer()
+	return endStream(sh, call, ctx, nil)
+}
+
+func (r *grpcRoute) handleClientStream(ctx *Context, cc *grpc.ClientConn) error {
+	call := r.call
+	sh := r.ch.(ClientStreamHandler)
+
+	callctx, cancel, err := r.streamContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	stream, err := cc.NewStream(callctx, &clientStreamDesc, call.Method, grpc.ForceCodec(&passthroughCodec{}))
+	if err != nil {
+		return err
+	}
+	err = sh.ReadStream(call, ctx, func(data []byte) error {
+		return stream.SendMsg(data)
+	})
+	// SendMsg 返回 io.EOF 说明服务端已经结束调用，真正的错误需要通过 RecvMsg 获取
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return err
+	}
+
+	var respData []byte
+	err = stream.RecvMsg(&respData)
+	ctx.header, _ = stream.Header()
+	ctx.trailer = stream.Trailer()
+	if len(call.Returns) > 0 {
+		copyReturnHeaders(ctx.resp.Header(), call.Returns, ctx.header, ctx.trailer)
+	}
+	if err != nil {
+		return err
+	}
+
+	return sh.WriteResponse(call, ctx, respData)
+}
+
+func (r *grpcRoute) handleBidiStream(ctx *Context, cc *grpc.ClientConn) error {
+	call := r.call
+	sh := r.ch.(BidiStreamHandler)
+
+	callctx, cancel, err := r.streamContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	stream, err := cc.NewStream(callctx, &bidiStreamDesc, call.Method, grpc.ForceCodec(&passthroughCodec{}))
+	if err != nil {
+		return err
+	}
+	return sh.ServeStream(call, ctx, stream)
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_grpcRoute_handleServerStream(t *testing.T) {
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		for _, s := range strings.Split(string(req), ",") {
+			if s == "fail" {
+				return status.Error(codes.Aborted, "aborted")
+			}
+			err = stream.SendMsg([]byte(s))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	e := NewBuilder().Build()
+	call := mockAddCall()
+	call.Method = "/gapi.testdata.Feed/Watch"
+	call.Stream = metadata.ServerStream
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantData   string
+		wantBegan  bool
+		wantEnded  codes.Code
+	}{
+		{name: "messages", body: "a,b,c", wantData: "a\nb\nc\n", wantBegan: true},
+		{name: "fail_first", body: "fail", wantStatus: http.StatusConflict},
+		{name: "fail_later", body: "a,fail", wantData: "a\n", wantBegan: true, wantEnded: codes.Aborted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &mockStreamHandler{}
+			gr := &grpcRoute{
+				engine:  e,
+				call:    call,
+				ch:      ch,
+				client:  &connPool{conns: []*grpc.ClientConn{cc}},
+				onError: e.onError,
+			}
+			req, err := http.NewRequest("POST", "http://localhost/watch", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := &mockResponse{}
+			gr.handleRoute(resp, req, nil)
+			if resp.statusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.statusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == 0 && string(resp.data) != tt.wantData {
+				t.Errorf("data = %q, want %q", resp.data, tt.wantData)
+			}
+			if ch.began != tt.wantBegan {
+				t.Errorf("began = %v, want %v", ch.began, tt.wantBegan)
+			}
+			if status.Code(ch.ended) != tt.wantEnded {
+				t.Errorf("ended = %v, want %v", ch.ended, tt.wantEnded)
+			}
+		})
+	}
+}
+
+func Test_grpcRoute_handleClientStream(t *testing.T) {
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		var parts []string
+		for {
+			var req []byte
+			err := stream.RecvMsg(&req)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if string(req) == "fail" {
+				return status.Error(codes.InvalidArgument, "invalid")
+			}
+			parts = append(parts, string(req))
+		}
+		return stream.SendMsg([]byte(strings.Join(parts, "+")))
+	})
+	e := NewBuilder().Build()
+	call := mockAddCall()
+	call.Method = "/gapi.testdata.Import/Upload"
+	call.Stream = metadata.ClientStream
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantData   string
+	}{
+		{name: "messages", body: "a,b,c", wantData: "a+b+c"},
+		{name: "fail", body: "a,fail,c", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gr := &grpcRoute{
+				engine:  e,
+				call:    call,
+				ch:      &mockClientStreamHandler{},
+				client:  &connPool{conns: []*grpc.ClientConn{cc}},
+				onError: e.onError,
+			}
+			req, err := http.NewRequest("POST", "http://localhost/upload", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp := &mockResponse{}
+			gr.handleRoute(resp, req, nil)
+			if resp.statusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.statusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == 0 && string(resp.data) != tt.wantData {
+				t.Errorf("data = %q, want %q", resp.data, tt.wantData)
+			}
+		})
+	}
+}
+
+func Test_grpcRoute_handleBidiStream(t *testing.T) {
+	cc := startMockServer(t, func(_ any, stream grpc.ServerStream) error {
+		for {
+			var req []byte
+			err := stream.RecvMsg(&req)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			err = stream.SendMsg(append([]byte("echo "), req...))
+			if err != nil {
+				return err
+			}
+		}
+	})
+	e := NewBuilder().Build()
+	call := mockAddCall()
+	call.Method = "/gapi.testdata.Chat/Talk"
+	call.Stream = metadata.BidiStream
+	gr := &grpcRoute{
+		engine:  e,
+		call:    call,
+		ch:      &mockBidiStreamHandler{},
+		client:  &connPool{conns: []*grpc.ClientConn{cc}},
+		onError: e.onError,
+	}
+	req, err := http.NewRequest("GET", "http://localhost/talk", strings.NewReader("a,b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &mockResponse{}
+	gr.handleRoute(resp, req, nil)
+	if resp.statusCode != 0 || string(resp.data) != "echo a\necho b\n" {
+		t.Fatalf("response %d: %q", resp.statusCode, resp.data)
+	}
+}
@@ -0,0 +1,261 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+type TLSConfig struct {
+	// Insecure 为 true 时使用明文连接，通常用于本机的 server
+	Insecure bool `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	// CAFile 为空时使用系统的根证书
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	// CertFile 和 KeyFile 同时设置时开启 mTLS
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+	// ServerName 覆盖 SNI 和证书校验使用的名字
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(name string) (fileStamp, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}, nil
+}
+
+// certFiles 在每次握手时检查证书文件，文件变化后重新加载，加载失败时继续使用旧的证书
+type certFiles struct {
+	cfg *TLSConfig
+	// name 是校验服务端证书使用的名字，可以是 IP
+	name string
+
+	mu      sync.Mutex
+	certMod fileStamp
+	keyMod  fileStamp
+	cert    *tls.Certificate
+	caMod   fileStamp
+	roots   *x509.CertPool
+}
+
+func (c *certFiles) certificate() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certMod, err := statFile(c.cfg.CertFile)
+	if err == nil {
+		var keyMod fileStamp
+		keyMod, err = statFile(c.cfg.KeyFile)
+		if err == nil && (c.cert == nil || certMod != c.certMod || keyMod != c.keyMod) {
+			var cert tls.Certificate
+			cert, err = tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+			if err == nil {
+				c.cert, c.certMod, c.keyMod = &cert, certMod, keyMod
+			}
+		}
+	}
+	if c.cert == nil {
+		return nil, err
+	}
+	return c.cert, nil
+}
+
+func (c *certFiles) rootCAs() (*x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	caMod, err := statFile(c.cfg.CAFile)
+	if err == nil && (c.roots == nil || caMod != c.caMod) {
+		var data []byte
+		data, err = os.ReadFile(c.cfg.CAFile)
+		if err == nil {
+			pool := x509.NewCertPool()
+			if pool.AppendCertsFromPEM(data) {
+				c.roots, c.caMod = pool, caMod
+			} else {
+				err = fmt.Errorf("no certificates in %s", c.cfg.CAFile)
+			}
+		}
+	}
+	if c.roots == nil {
+		return nil, err
+	}
+	return c.roots, nil
+}
+
+func (c *certFiles) verifyConnection(cs tls.ConnectionState) error {
+	roots, err := c.rootCAs()
+	if err != nil {
+		return err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no peer certificates")
+	}
+	// 不能使用 cs.ServerName，客户端连接 IP 时不会发送 SNI，cs.ServerName 为空时会跳过名字校验
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       c.name,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// serverHost 返回 server 中的主机名，server 可以带有 scheme 和端口
+func serverHost(server string) (string, error) {
+	if strings.Contains(server, ",") {
+		return "", errors.New("server_name is required for server list")
+	}
+	if i := strings.LastIndexByte(server, '/'); i >= 0 {
+		server = server[i+1:]
+	}
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+	}
+	if host == "" {
+		return "", fmt.Errorf("no host in %q", server)
+	}
+	return host, nil
+}
+
+// newTransportCredentials 创建连接 server 使用的证书配置，没有设置 ServerName 时使用 server 的主机名校验证书
+func newTransportCredentials(cfg *TLSConfig, server string) (credentials.TransportCredentials, error) {
+	if cfg == nil || cfg.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	name := cfg.ServerName
+	if name == "" {
+		var err error
+		name, err = serverHost(server)
+		if err != nil {
+			return nil, err
+		}
+	}
+	files := &certFiles{cfg: cfg, name: name}
+	tlsConfig := &tls.Config{
+		ServerName: name,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errors.New("both cert_file and key_file are required")
+		}
+		// 提前加载一次，配置错误时在连接前发现
+		_, err := files.certificate()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return files.certificate()
+		}
+	}
+	if cfg.CAFile != "" {
+		_, err := files.rootCAs()
+		if err != nil {
+			return nil, err
+		}
+		// 使用自定义校验才能在 CA 文件变化后使用新的根证书
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = files.verifyConnection
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// TLSDialer 按 server 选择连接使用的证书配置，Configs 中没有的 server 使用 Default，Default 为 nil 时使用明文连接。
+// 没有设置 ServerName 时按 server 的主机名（可以是 IP）校验证书，upstream 按 upstream 的名字校验
+type TLSDialer struct {
+	Configs map[string]*TLSConfig
+	Default *TLSConfig
+	Opts    []grpc.DialOption
+
+	mu    sync.Mutex
+	creds map[credsKey]credentials.TransportCredentials
+}
+
+// credsKey 区分使用同一个配置的不同 server，每个 server 校验的名字可能不同
+type credsKey struct {
+	cfg    *TLSConfig
+	server string
+}
+
+func (d *TLSDialer) transportCredentials(server string) (credentials.TransportCredentials, error) {
+	cfg := d.Configs[server]
+	if cfg == nil {
+		cfg = d.Default
+	}
+	if cfg == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := credsKey{cfg: cfg, server: server}
+	creds := d.creds[key]
+	if creds != nil {
+		return creds, nil
+	}
+	creds, err := newTransportCredentials(cfg, server)
+	if err != nil {
+		return nil, fmt.Errorf("tls config of %s: %w", server, err)
+	}
+	if d.creds == nil {
+		d.creds = make(map[credsKey]credentials.TransportCredentials)
+	}
+	d.creds[key] = creds
+	return creds, nil
+}
+
+func (d *TLSDialer) dialer(server string) (*GrpcDialer, error) {
+	creds, err := d.transportCredentials(server)
+	if err != nil {
+		return nil, err
+	}
+	opts := append(d.Opts[:len(d.Opts):len(d.Opts)], grpc.WithTransportCredentials(creds))
+	return &GrpcDialer{Opts: opts}, nil
+}
+
+func (d *TLSDialer) Dial(server string) (*grpc.ClientConn, error) {
+	return d.DialContext(context.Background(), server)
+}
+
+func (d *TLSDialer) DialContext(ctx context.Context, server string) (*grpc.ClientConn, error) {
+	gd, err := d.dialer(server)
+	if err != nil {
+		return nil, err
+	}
+	return gd.DialContext(ctx, server)
+}
+
+func (d *TLSDialer) DialUpstream(name string, upstream *Upstream) (*grpc.ClientConn, error) {
+	return d.DialUpstreamContext(context.Background(), name, upstream)
+}
+
+func (d *TLSDialer) DialUpstreamContext(ctx context.Context, name string, upstream *Upstream) (*grpc.ClientConn, error) {
+	gd, err := d.dialer(name)
+	if err != nil {
+		return nil, err
+	}
+	return gd.DialUpstreamContext(ctx, name, upstream)
+}
@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+var testSerial int64
+
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSerial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{name},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.DNSNames, tmpl.IPAddresses = nil, []net.IP{ip}
+	}
+	parentCert, parentKey := tmpl, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeRotated 写入文件并修改时间，保证和上一次写入的时间不同
+func writeRotated(t *testing.T, name string, data []byte, version int) {
+	err := os.WriteFile(name, data, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(time.Duration(version) * time.Second)
+	err = os.Chtimes(name, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSDialer(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	otherCA := newTestCert(t, "other-ca", nil, true)
+	serverCert := newTestCert(t, "backend.test", ca, false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	serverTLS, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverTLS},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})), grpc.ForceServerCodec(&passthroughCodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err != nil {
+			return err
+		}
+		return stream.SendMsg([]byte("ok"))
+	}))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	dir := t.TempDir()
+	cfg := &TLSConfig{
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		CertFile:   filepath.Join(dir, "client.pem"),
+		KeyFile:    filepath.Join(dir, "client.key"),
+		ServerName: "backend.test",
+	}
+	server := lis.Addr().String()
+	d := &TLSDialer{
+		Configs: map[string]*TLSConfig{server: cfg},
+		Default: &TLSConfig{Insecure: true},
+	}
+
+	_, err = d.Dial(server)
+	if err == nil {
+		t.Fatal("expected error for missing files")
+	}
+
+	invoke := func() error {
+		cc, err := d.Dial(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cc.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var resp []byte
+		return cc.Invoke(ctx, "/pdtest.Calc/Add", []byte{}, &resp, grpc.ForceCodec(&passthroughCodec{}))
+	}
+
+	client := newTestCert(t, "client", ca, false)
+	writeRotated(t, cfg.CAFile, otherCA.certPEM, 1)
+	writeRotated(t, cfg.CertFile, client.certPEM, 1)
+	writeRotated(t, cfg.KeyFile, client.keyPEM, 1)
+	if err := invoke(); err == nil {
+		t.Fatal("expected error for unknown server CA")
+	}
+
+	writeRotated(t, cfg.CAFile, ca.certPEM, 2)
+	if err := invoke(); err != nil {
+		t.Fatal(err)
+	}
+
+	rogue := newTestCert(t, "client", otherCA, false)
+	writeRotated(t, cfg.CertFile, rogue.certPEM, 3)
+	writeRotated(t, cfg.KeyFile, rogue.keyPEM, 3)
+	if err := invoke(); err == nil {
+		t.Fatal("expected error for rejected client certificate")
+	}
+
+	rotated := newTestCert(t, "client", ca, false)
+	writeRotated(t, cfg.CertFile, rotated.certPEM, 4)
+	writeRotated(t, cfg.KeyFile, rotated.keyPEM, 4)
+	if err := invoke(); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := d.transportCredentials("127.0.0.1:1")
+	if err != nil || creds.Info().SecurityProtocol != "insecure" {
+		t.Fatalf("default credentials: %v %v", creds, err)
+	}
+}
+
+func TestTLSDialer_VerifyIP(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeRotated(t, caFile, ca.certPEM, 1)
+
+	invoke := func(serverCert *testCert, cfg *TLSConfig) error {
+		serverTLS, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{serverTLS}})),
+			grpc.ForceServerCodec(&passthroughCodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+				var req []byte
+				err := stream.RecvMsg(&req)
+				if err != nil {
+					return err
+				}
+				return stream.SendMsg([]byte("ok"))
+			}))
+		go srv.Serve(lis)
+		defer srv.Stop()
+
+		d := &TLSDialer{Default: cfg}
+		cc, err := d.Dial(lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cc.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var resp []byte
+		return cc.Invoke(ctx, "/pdtest.Calc/Add", []byte{}, &resp, grpc.ForceCodec(&passthroughCodec{}))
+	}
+
+	// 由同一个 CA 签发但名字不匹配的证书必须被拒绝
+	if err := invoke(newTestCert(t, "other.test", ca, false), &TLSConfig{CAFile: caFile}); err == nil {
+		t.Fatal("expected error for certificate without matching IP")
+	}
+	if err := invoke(newTestCert(t, "127.0.0.1", ca, false), &TLSConfig{CAFile: caFile}); err != nil {
+		t.Fatal(err)
+	}
+	if err := invoke(newTestCert(t, "other.test", ca, false), &TLSConfig{CAFile: caFile, ServerName: "other.test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := invoke(newTestCert(t, "127.0.0.1", ca, false), &TLSConfig{CAFile: caFile, ServerName: "other.test"}); err == nil {
+		t.Fatal("expected error for certificate without matching server name")
+	}
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+type BalancePolicy string
+
+const (
+	RoundRobin   BalancePolicy = "round_robin"
+	LeastRequest BalancePolicy = "least_request"
+	Weighted     BalancePolicy = "weighted"
+)
+
+var balancerNames = map[BalancePolicy]string{
+	"":           "round_robin",
+	RoundRobin:   "round_robin",
+	LeastRequest: leastRequestBalancer,
+	Weighted:     weightedBalancer,
+}
+
+type Endpoint struct {
+	Addr string
+	// Weight 仅在 Weighted 策略下生效，0 视为 1
+	Weight uint32
+}
+
+// Upstream 是由多个地址组成的 server，按 Policy 在地址间分配请求
+type Upstream struct {
+	Policy    BalancePolicy
+	Endpoints []Endpoint
+}
+
+// UpstreamDialer 由 Dialer 选择实现，实现后 Call.Server 可以是 Builder 中定义的 upstream 或者以 , 分隔的多个地址
+type UpstreamDialer interface {
+	DialUpstream(name string, upstream *Upstream) (*grpc.ClientConn, error)
+}
+
+// ContextUpstreamDialer 是 UpstreamDialer 可以取消的版本，与 ContextDialer 相同
+type ContextUpstreamDialer interface {
+	DialUpstreamContext(ctx context.Context, name string, upstream *Upstream) (*grpc.ClientConn, error)
+}
+
+const upstreamScheme = "gapi-upstream"
+
+func (d *GrpcDialer) DialUpstream(name string, upstream *Upstream) (*grpc.ClientConn, error) {
+	return d.DialUpstreamContext(context.Background(), name, upstream)
+}
+
+func (d *GrpcDialer) DialUpstreamContext(ctx context.Context, name string, upstream *Upstream) (*grpc.ClientConn, error) {
+	lb, ok := balancerNames[upstream.Policy]
+	if !ok {
+		return nil, fmt.Errorf("unknown balance policy %q", upstream.Policy)
+	}
+	if len(upstream.Endpoints) == 0 {
+		return nil, errors.New("upstream has no endpoints")
+	}
+	addrs := make([]resolver.Address, 0, len(upstream.Endpoints))
+	for _, ep := range upstream.Endpoints {
+		addrs = append(addrs, withWeight(resolver.Address{Addr: ep.Addr}, ep.Weight))
+	}
+	// 每个连接使用独立的 resolver，不依赖全局注册
+	r := manual.NewBuilderWithScheme(upstreamScheme)
+	r.InitialState(resolver.State{Addresses: addrs})
+	opts := append(d.Opts[:len(d.Opts):len(d.Opts)],
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, lb)))
+	return grpc.DialContext(ctx, upstreamScheme+":///"+name, opts...)
+}
+
+// parseServerList 把 a:1,b:2 形式的 server 解析为轮询的 upstream
+func parseServerList(server string) *Upstream {
+	if !strings.Contains(server, ",") {
+		return nil
+	}
+	u := &Upstream{Policy: RoundRobin}
+	for _, addr := range strings.Split(server, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			u.Endpoints = append(u.Endpoints, Endpoint{Addr: addr})
+		}
+	}
+	return u
+}
+
+// dial 在 Dialer 实现了 ContextDialer 或 ContextUpstreamDialer 时使用 ctx，否则 ctx 不起作用
+func (e *Engine) dial(ctx context.Context, server string) (*grpc.ClientConn, error) {
+	upstream := e.upstreams[server]
+	if upstream == nil {
+		upstream = parseServerList(server)
+	}
+	if upstream == nil {
+		if cd, ok := e.dialer.(ContextDialer); ok {
+			return cd.DialContext(ctx, server)
+		}
+		return e.dialer.Dial(server)
+	}
+	if cd, ok := e.dialer.(ContextUpstreamDialer); ok {
+		return cd.DialUpstreamContext(ctx, server, upstream)
+	}
+	ud, ok := e.dialer.(UpstreamDialer)
+	if !ok {
+		return nil, fmt.Errorf("dialer does not support upstream %s", server)
+	}
+	return ud.DialUpstream(server, upstream)
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type mockSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func mockPickerBuildInfo(weights map[string]uint32) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	for name, w := range weights {
+		info.ReadySCs[&mockSubConn{name: name}] = base.SubConnInfo{Address: withWeight(resolver.Address{Addr: name}, w)}
+	}
+	return info
+}
+
+func TestWeightedPicker(t *testing.T) {
+	p := (&weightedPickerBuilder{}).Build(mockPickerBuildInfo(map[string]uint32{"a": 1, "b": 3, "c": 0}))
+	counts := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[res.SubConn.(*mockSubConn).name]++
+	}
+	if counts["a"] != 10 || counts["b"] != 30 || counts["c"] != 10 {
+		t.Fatalf("counts: %v", counts)
+	}
+}
+
+func TestLeastRequestPicker(t *testing.T) {
+	p := (&leastRequestPickerBuilder{}).Build(mockPickerBuildInfo(map[string]uint32{"a": 0, "b": 0}))
+	first, err := p.Pick(balancer.PickInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// first 未结束时总是选择另一个
+	for i := 0; i < 3; i++ {
+		res, _ := p.Pick(balancer.PickInfo{})
+		if res.SubConn == first.SubConn {
+			t.Fatal("picked busy subconn")
+		}
+		res.Done(balancer.DoneInfo{})
+	}
+	first.Done(balancer.DoneInfo{})
+
+	counts := make(map[balancer.SubConn]int)
+	for i := 0; i < 4; i++ {
+		res, _ := p.Pick(balancer.PickInfo{})
+		counts[res.SubConn]++
+		res.Done(balancer.DoneInfo{})
+	}
+	if len(counts) != 2 {
+		t.Fatalf("counts: %v", counts)
+	}
+
+	_, err = (&leastRequestPickerBuilder{}).Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	if err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEngine_DialUpstream(t *testing.T) {
+	listeners := make(map[string]*bufconn.Listener)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		lis := bufconn.Listen(1 << 20)
+		srv := grpc.NewServer(grpc.ForceServerCodec(&passthroughCodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			var req []byte
+			err := stream.RecvMsg(&req)
+			if err != nil {
+				return err
+			}
+			return stream.SendMsg([]byte(name))
+		}))
+		go srv.Serve(lis)
+		t.Cleanup(srv.Stop)
+		listeners[name] = lis
+	}
+
+	builder := NewBuilder()
+	builder.Dialer(&GrpcDialer{Opts: []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return listeners[addr].DialContext(ctx)
+		}),
+	}})
+	builder.Upstream("calc", &Upstream{Policy: LeastRequest, Endpoints: []Endpoint{{Addr: "a"}, {Addr: "b"}}})
+	builder.Upstream("broken", &Upstream{Policy: "random", Endpoints: []Endpoint{{Addr: "a"}}})
+	e := builder.Build()
+
+	_, err := e.dial(context.Background(), "broken")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	for _, server := range []string{"calc", "a,b"} {
+		cc, err := e.dial(context.Background(), server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		deadline := time.Now().Add(5 * time.Second)
+		for len(seen) < 2 && time.Now().Before(deadline) {
+			var resp []byte
+			err = cc.Invoke(context.Background(), "/pdtest.Calc/Add", []byte{}, &resp, grpc.ForceCodec(&passthroughCodec{}), grpc.WaitForReady(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[string(resp)] = true
+		}
+		cc.Close()
+		if len(seen) != 2 {
+			t.Fatalf("%s: endpoints %v", server, seen)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+)
+
+type IssueKind uint32
+
+const (
+	IssueInvalidRoute IssueKind = iota + 1
+	IssueHandler
+	IssueStream
+	IssueMiddleware
+	IssueDuplicate
+	IssueConflict
+	IssueBinding
+)
+
+var issueKindNames = map[IssueKind]string{
+	IssueInvalidRoute: "invalid route",
+	IssueHandler:      "handler",
+	IssueStream:       "stream",
+	IssueMiddleware:   "middleware",
+	IssueDuplicate:    "duplicate",
+	IssueConflict:     "conflict",
+	IssueBinding:      "binding",
+}
+
+func (k IssueKind) String() string {
+	name, ok := issueKindNames[k]
+	if !ok {
+		return fmt.Sprintf("IssueKind(%d)", k)
+	}
+	return name
+}
+
+type RouteIssue struct {
+	Index   int
+	Method  string
+	Path    string
+	Kind    IssueKind
+	Message string
+}
+
+func (i *RouteIssue) Error() string {
+	return fmt.Sprintf("route #%d %s %s: %s: %s", i.Index, i.Method, i.Path, i.Kind, i.Message)
+}
+
+type ValidationReport struct {
+	Routes int
+	Issues []RouteIssue
+}
+
+func (r *ValidationReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *ValidationReport) Err() error {
+	if len(r.Issues) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(r.Issues))
+	for i := range r.Issues {
+		errs = append(errs, &r.Issues[i])
+	}
+	return errors.Join(errs...)
+}
+
+func (r *ValidationReport) add(index int, route *metadata.Route, kind IssueKind, format string, args ...any) {
+	r.Issues = append(r.Issues, RouteIssue{
+		Index:   index,
+		Method:  route.Method,
+		Path:    route.Path,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// bindingKindMatch 检查绑定类型和字段类型是否一致，绑定只会以对应的类型编码
+func bindingKindMatch(b jsonpb.Kind, f jsonpb.Kind) bool {
+	switch b {
+	case jsonpb.Int32Kind:
+		return f == jsonpb.Int32Kind || f == jsonpb.Uint32Kind
+	case jsonpb.Int64Kind:
+		return f == jsonpb.Int64Kind || f == jsonpb.Uint64Kind
+	}
+	return b == f
+}
+
+func validateBindings(report *ValidationReport, index int, route *metadata.Route, call *metadata.Call) {
+	for _, b := range call.Bindings {
+		switch b.Bind {
+		case metadata.BindQuery, metadata.BindParams, metadata.BindHeader, metadata.BindContext:
+		default:
+			report.add(index, route, IssueBinding, "binding %s has unsupported source %d", b.Name, b.Bind)
+			continue
+		}
+		switch b.Kind {
+		case jsonpb.Int32Kind, jsonpb.Int64Kind, jsonpb.StringKind, jsonpb.BoolKind:
+		default:
+			report.add(index, route, IssueBinding, "binding %s has unsupported kind %d", b.Name, b.Kind)
+			continue
+		}
+		if call.In == nil {
+			report.add(index, route, IssueBinding, "binding %s without input message", b.Name)
+			continue
+		}
+		field := call.In.FieldByTag(b.Tag)
+		if field == nil {
+			report.add(index, route, IssueBinding, "binding %s tag %d not found in %s", b.Name, b.Tag, call.In.Name)
+			continue
+		}
+		if field.Repeated || !bindingKindMatch(b.Kind, field.Kind) {
+			report.add(index, route, IssueBinding, "binding %s kind %d mismatches field %s", b.Name, b.Kind, field.Name)
+		}
+	}
+}
+
+func (e *Engine) validateCall(report *ValidationReport, index int, route *metadata.Route, call *metadata.Call) {
+	if call.Method == "" {
+		report.add(index, route, IssueInvalidRoute, "method not specified")
+	}
+	ch := e.handlers[call.Handler]
+	if ch == nil {
+		report.add(index, route, IssueHandler, "handler %s not found", call.Handler)
+	} else if !supportStream(ch, call.Stream) {
+		report.add(index, route, IssueStream, "handler %s does not support stream type %d", call.Handler, call.Stream)
+	}
+	validateBindings(report, index, route, call)
+}
+
+// ValidateEngineRoutes 对路由执行 RebuildEngineRouter 中的所有检查以及额外的绑定检查，
+// 不会建立连接，也不会影响当前的 router
+func ValidateEngineRoutes[R RouteIter](e *Engine, routeIter R) *ValidationReport {
+	report := &ValidationReport{}
+	router := httprouter.New()
+	seen := make(map[string]int)
+	nop := func(http.ResponseWriter, *http.Request, httprouter.Params) {}
+	for index := 0; ; index++ {
+		route := routeIter.NextRoute()
+		if route == nil {
+			break
+		}
+		report.Routes++
+
+		if route.Call == nil {
+			report.add(index, route, IssueInvalidRoute, "call not specified")
+			continue
+		}
+		for _, name := range route.Use {
+			if e.middlewares[name] == nil {
+				report.add(index, route, IssueMiddleware, "no such middleware %s", name)
+			}
+		}
+		e.validateCall(report, index, route, route.Call)
+		if route.Split != nil {
+			total := route.Split.Weight
+			for i := range route.Split.Targets {
+				t := &route.Split.Targets[i]
+				total += t.Weight
+				if t.Call == nil {
+					report.add(index, route, IssueInvalidRoute, "split target #%d call not specified", i)
+					continue
+				}
+				e.validateCall(report, index, route, t.Call)
+			}
+			if total == 0 {
+				report.add(index, route, IssueInvalidRoute, "split has no weight")
+			}
+		}
+
+		key := route.Method + " " + route.Path
+		if first, ok := seen[key]; ok {
+			report.add(index, route, IssueDuplicate, "duplicate of route #%d", first)
+			continue
+		}
+		seen[key] = index
+		err := registerRoute(router, route.Method, route.Path, nop)
+		if err != nil {
+			report.add(index, route, IssueConflict, "%v", err)
+		}
+	}
+	return report
+}
+
+func (e *Engine) Validate(routes []*metadata.Route) *ValidationReport {
+	return ValidateEngineRoutes(e, &routesSliceIter{rs: routes})
+}
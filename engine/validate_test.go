@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+)
+
+func TestEngine_Validate(t *testing.T) {
+	builder := NewBuilder()
+	builder.RegisterHandler("mock-handler", &mockHandler{})
+	builder.RegisterMiddleware("auth", func(ctx *Context) error {
+		return ctx.Next()
+	})
+	e := builder.Build()
+	router := e.gen.Load()
+
+	bound := mockAddCall()
+	bound.Bindings = []metadata.FieldBinding{
+		{Name: "a", Kind: jsonpb.Int32Kind, Tag: 1, Bind: metadata.BindQuery},
+		{Name: "b", Kind: jsonpb.StringKind, Tag: 2, Bind: metadata.BindQuery},
+		{Name: "c", Kind: jsonpb.Int32Kind, Tag: 3, Bind: metadata.BindParams},
+	}
+	noHandler := mockAddCall()
+	noHandler.Handler = "unknown"
+	stream := mockAddCall()
+	stream.Stream = metadata.ServerStream
+
+	report := e.Validate([]*metadata.Route{
+		{Method: "POST", Path: "/add", Use: []string{"auth"}, Call: mockAddCall()},
+		{Method: "POST", Path: "/add", Call: mockAddCall()},
+		{Method: "POST", Path: "/:op", Call: mockAddCall()},
+		{Method: "POST", Path: "/bound", Use: []string{"log"}, Call: bound},
+		{Method: "POST", Path: "/unknown", Call: noHandler},
+		{Method: "POST", Path: "/stream", Call: stream},
+		{Method: "POST", Path: "/nocall"},
+	})
+	if report.Routes != 7 {
+		t.Fatalf("routes: %d", report.Routes)
+	}
+	want := []struct {
+		index int
+		kind  IssueKind
+	}{
+		{1, IssueDuplicate},
+		{2, IssueConflict},
+		{3, IssueMiddleware},
+		{3, IssueBinding},
+		{3, IssueBinding},
+		{4, IssueHandler},
+		{5, IssueStream},
+		{6, IssueInvalidRoute},
+	}
+	if len(report.Issues) != len(want) {
+		t.Fatalf("issues: %v", report.Err())
+	}
+	for i, w := range want {
+		issue := report.Issues[i]
+		if issue.Index != w.index || issue.Kind != w.kind {
+			t.Errorf("issue %d: %v", i, &issue)
+		}
+	}
+	if e.gen.Load() != router {
+		t.Fatal("router changed")
+	}
+
+	report = e.Validate([]*metadata.Route{
+		{Method: "POST", Path: "/add", Use: []string{"auth"}, Call: mockAddCall()},
+	})
+	if !report.OK() || report.Err() != nil {
+		t.Fatalf("unexpected issues: %v", report.Err())
+	}
+}
@@ -3,11 +3,14 @@ module github.com/vizee/gapi
 go 1.20
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/vizee/gapi-proto-go v0.0.0-20230505112701-bca324ad1c1e
 	github.com/vizee/jsonpb v0.2.0
 	google.golang.org/grpc v1.55.0
 	google.golang.org/protobuf v1.30.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
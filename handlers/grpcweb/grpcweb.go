@@ -0,0 +1,201 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	contentTypeWeb     = "application/grpc-web"
+	contentTypeWebText = "application/grpc-web-text"
+
+	frameHeaderSize = 5
+	flagCompressed  = 0x01
+	flagTrailer     = 0x80
+)
+
+var (
+	_ engine.CallHandler = &Handler{}
+	_ engine.ErrorWriter = &Handler{}
+)
+
+// Handler 处理 gRPC-Web 请求，请求和响应的消息体都原样转发
+type Handler struct {
+}
+
+func isTextMode(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), contentTypeWebText)
+}
+
+// decodeText 解码 base64 请求体，请求体可能由多段各自补齐的 base64 组成
+func decodeText(src []byte) ([]byte, error) {
+	dst := make([]byte, base64.StdEncoding.DecodedLen(len(src)))
+	n := 0
+	for len(src) > 0 {
+		end := bytes.IndexByte(src, '=')
+		if end < 0 {
+			end = len(src)
+		} else {
+			for end < len(src) && src[end] == '=' {
+				end++
+			}
+		}
+		nn, err := base64.StdEncoding.Decode(dst[n:], src[:end])
+		if err != nil {
+			return nil, err
+		}
+		n += nn
+		src = src[end:]
+	}
+	return dst[:n], nil
+}
+
+func (*Handler) ReadRequest(_ *metadata.Call, ctx *engine.Context) ([]byte, error) {
+	req := ctx.Request()
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), contentTypeWeb) {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported content type %q", req.Header.Get("Content-Type"))
+	}
+	data, err := ctx.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	if isTextMode(req) {
+		data, err = decodeText(bytes.TrimSpace(data))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "malformed base64 body: %v", err)
+		}
+	}
+
+	if len(data) < frameHeaderSize {
+		return nil, status.Error(codes.InvalidArgument, "malformed grpc-web frame")
+	}
+	if data[0]&flagCompressed != 0 {
+		return nil, status.Error(codes.Unimplemented, "compressed grpc-web frame is not supported")
+	}
+	n := binary.BigEndian.Uint32(data[1:frameHeaderSize])
+	if uint64(len(data)-frameHeaderSize) < uint64(n) {
+		return nil, status.Error(codes.InvalidArgument, "malformed grpc-web frame")
+	}
+	return data[frameHeaderSize : frameHeaderSize+int(n)], nil
+}
+
+func appendFrame(buf []byte, flag byte, data []byte) []byte {
+	buf = append(buf, flag, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(len(data)))
+	return append(buf, data...)
+}
+
+// encodeMessage 按 gRPC 的规则对 grpc-message 做百分号编码
+func encodeMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString("%")
+			sb.WriteString(strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return sb.String()
+}
+
+func isReservedHeader(name string) bool {
+	return name == "content-type" || strings.HasPrefix(name, "grpc-") || strings.HasPrefix(name, ":")
+}
+
+// copyHeaders 把 mds 复制到 h，跳过调用前 h 中已有的 key，例如由 Call.Returns 写回的 header
+// encodeValue 和 GRPC 一样对 -bin 结尾的 key 的值做 base64 编码
+func encodeValue(k string, v string) string {
+	if strings.HasSuffix(k, "-bin") {
+		return base64.RawStdEncoding.EncodeToString([]byte(v))
+	}
+	return v
+}
+
+func copyHeaders(h http.Header, mds ...grpcmd.MD) {
+	present := make(map[string]bool, len(h))
+	for k := range h {
+		present[k] = true
+	}
+	for _, md := range mds {
+		for k, vals := range md {
+			if isReservedHeader(k) || present[http.CanonicalHeaderKey(k)] {
+				continue
+			}
+			for _, v := range vals {
+				h.Add(k, encodeValue(k, v))
+			}
+		}
+	}
+}
+
+func appendTrailers(buf []byte, st *status.Status, md grpcmd.MD) []byte {
+	buf = append(buf, "grpc-status: "...)
+	buf = strconv.AppendUint(buf, uint64(st.Code()), 10)
+	buf = append(buf, "\r\ngrpc-message: "...)
+	buf = append(buf, encodeMessage(st.Message())...)
+	buf = append(buf, "\r\n"...)
+	for k, vals := range md {
+		if isReservedHeader(k) {
+			continue
+		}
+		for _, v := range vals {
+			buf = append(buf, k...)
+			buf = append(buf, ": "...)
+			buf = append(buf, encodeValue(k, v)...)
+			buf = append(buf, "\r\n"...)
+		}
+	}
+	return buf
+}
+
+func writeBody(ctx *engine.Context, body []byte) error {
+	resp := ctx.Response()
+	if isTextMode(ctx.Request()) {
+		resp.Header().Set("Content-Type", contentTypeWebText+"+proto")
+		text := make([]byte, base64.StdEncoding.EncodedLen(len(body)))
+		base64.StdEncoding.Encode(text, body)
+		body = text
+	} else {
+		resp.Header().Set("Content-Type", contentTypeWeb+"+proto")
+	}
+	resp.WriteHeader(http.StatusOK)
+	_, err := resp.Write(body)
+	return err
+}
+
+func (*Handler) WriteResponse(_ *metadata.Call, ctx *engine.Context, data []byte) error {
+	copyHeaders(ctx.Response().Header(), ctx.GrpcHeader())
+
+	trailers := appendTrailers(nil, status.New(codes.OK, ""), ctx.GrpcTrailer())
+	body := make([]byte, 0, frameHeaderSize*2+len(data)+len(trailers))
+	body = appendFrame(body, 0, data)
+	body = appendFrame(body, flagTrailer, trailers)
+	return writeBody(ctx, body)
+}
+
+// WriteError 以 trailers-only 的形式输出错误，HTTP 状态码总是 200
+func (*Handler) WriteError(_ *metadata.Call, ctx *engine.Context, err error) error {
+	// 非 GRPC 状态的错误可能包含内部信息，只输出状态码描述
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.New(codes.Unknown, http.StatusText(ctx.ErrorStatus(err)))
+	}
+
+	h := ctx.Response().Header()
+	copyHeaders(h, ctx.GrpcHeader(), ctx.GrpcTrailer())
+	h.Set("Grpc-Status", strconv.Itoa(int(st.Code())))
+	h.Set("Grpc-Message", encodeMessage(st.Message()))
+	return writeBody(ctx, nil)
+}
@@ -0,0 +1,200 @@
+package grpcweb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return v.([]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+type connDialer struct {
+	cc *grpc.ClientConn
+}
+
+func (d connDialer) Dial(string) (*grpc.ClientConn, error) {
+	return d.cc, nil
+}
+
+// echoHandler 在消息前加上 echo: 返回，消息为 bin 时附加二进制元数据，为 fail 时返回错误
+func echoHandler(_ any, stream grpc.ServerStream) error {
+	var req []byte
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+	stream.SetHeader(grpcmd.Pairs("x-h", "1"))
+	stream.SetTrailer(grpcmd.Pairs("x-t", "2"))
+	switch string(req) {
+	case "bin":
+		stream.SetHeader(grpcmd.Pairs("x-h-bin", "\xff\x00"))
+		stream.SetTrailer(grpcmd.Pairs("x-t-bin", "\xfe"))
+	case "fail":
+		return status.Error(codes.FailedPrecondition, "bad\nstate 100%")
+	}
+	return stream.SendMsg(append([]byte("echo:"), req...))
+}
+
+func newTestEngine(t *testing.T) *engine.Engine {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(echoHandler))
+	go srv.Serve(lis)
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Stop()
+	})
+
+	builder := engine.NewBuilder()
+	builder.Dialer(connDialer{cc: cc})
+	builder.RegisterHandler("grpcweb", &Handler{})
+	e := builder.Build()
+	err = e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/echo", Call: &metadata.Call{Server: "echo", Handler: "grpcweb", Method: "/test.Echo/Echo"}},
+		{Method: "POST", Path: "/returns", Call: &metadata.Call{Server: "echo", Handler: "grpcweb", Method: "/test.Echo/Echo", Returns: []string{"x-h"}}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func frame(flag byte, data []byte) []byte {
+	return appendFrame(nil, flag, data)
+}
+
+func serve(e *engine.Engine, path string, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "http://localhost"+path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, req)
+	return resp
+}
+
+func successBody(msg string) []byte {
+	body := frame(0, []byte("echo:"+msg))
+	return append(body, frame(flagTrailer, []byte("grpc-status: 0\r\ngrpc-message: \r\nx-t: 2\r\n"))...)
+}
+
+func TestHandler_Binary(t *testing.T) {
+	e := newTestEngine(t)
+	resp := serve(e, "/echo", contentTypeWeb+"+proto", frame(0, []byte("hello")))
+	if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != contentTypeWeb+"+proto" {
+		t.Fatalf("response %d: %v", resp.Code, resp.Header())
+	}
+	if resp.Header().Get("X-H") != "1" {
+		t.Fatalf("header: %v", resp.Header())
+	}
+	if !bytes.Equal(resp.Body.Bytes(), successBody("hello")) {
+		t.Fatalf("body: %q", resp.Body.Bytes())
+	}
+}
+
+func TestHandler_Text(t *testing.T) {
+	e := newTestEngine(t)
+	data := frame(0, []byte("hello"))
+	// 两段各自补齐的 base64
+	segmented := base64.StdEncoding.EncodeToString(data[:4]) + base64.StdEncoding.EncodeToString(data[4:])
+	for _, body := range []string{base64.StdEncoding.EncodeToString(data), segmented} {
+		resp := serve(e, "/echo", contentTypeWebText, []byte(body))
+		if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != contentTypeWebText+"+proto" {
+			t.Fatalf("response %d: %v", resp.Code, resp.Header())
+		}
+		got, err := base64.StdEncoding.DecodeString(resp.Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, successBody("hello")) {
+			t.Fatalf("body: %q", got)
+		}
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	e := newTestEngine(t)
+	truncated := frame(0, []byte("hello"))
+	binary.BigEndian.PutUint32(truncated[1:], 10)
+	tests := []struct {
+		name    string
+		body    []byte
+		code    codes.Code
+		message string
+	}{
+		{"truncated", truncated, codes.InvalidArgument, "malformed grpc-web frame"},
+		{"short", []byte{0, 0}, codes.InvalidArgument, "malformed grpc-web frame"},
+		{"compressed", frame(flagCompressed, []byte("hello")), codes.Unimplemented, "compressed grpc-web frame is not supported"},
+		{"status", frame(0, []byte("fail")), codes.FailedPrecondition, "bad%0Astate 100%25"},
+	}
+	for _, tt := range tests {
+		resp := serve(e, "/echo", contentTypeWeb, tt.body)
+		h := resp.Header()
+		if resp.Code != http.StatusOK || resp.Body.Len() != 0 {
+			t.Fatalf("%s: response %d: %q", tt.name, resp.Code, resp.Body.Bytes())
+		}
+		if h.Get("Grpc-Status") != strconv.Itoa(int(tt.code)) || h.Get("Grpc-Message") != tt.message {
+			t.Fatalf("%s: trailers %v", tt.name, h)
+		}
+		if tt.code == codes.FailedPrecondition && (h.Get("X-H") != "1" || h.Get("X-T") != "2") {
+			t.Fatalf("%s: metadata %v", tt.name, h)
+		}
+	}
+}
+
+func TestHandler_ReturnsNotDuplicated(t *testing.T) {
+	e := newTestEngine(t)
+	resp := serve(e, "/returns", contentTypeWeb, frame(0, []byte("hello")))
+	if vals := resp.Header().Values("X-H"); len(vals) != 1 {
+		t.Fatalf("x-h: %v", vals)
+	}
+	resp = serve(e, "/returns", contentTypeWeb, frame(0, []byte("fail")))
+	if vals := resp.Header().Values("X-H"); len(vals) != 1 {
+		t.Fatalf("x-h: %v", vals)
+	}
+}
+
+func TestHandler_BinaryMetadata(t *testing.T) {
+	e := newTestEngine(t)
+	resp := serve(e, "/echo", contentTypeWeb, frame(0, []byte("bin")))
+	if v := resp.Header().Get("X-H-Bin"); v != "/wA" {
+		t.Fatalf("x-h-bin: %q", v)
+	}
+	want := frame(0, []byte("echo:bin"))
+	if body := resp.Body.Bytes(); !bytes.HasPrefix(body, want) || !bytes.Contains(body[len(want):], []byte("x-t-bin: /g\r\n")) {
+		t.Fatalf("body %q", body)
+	}
+}
@@ -1,41 +1,62 @@
 package jsonapi
 
 import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
 	"github.com/vizee/gapi/engine"
 	"github.com/vizee/gapi/metadata"
 	"github.com/vizee/jsonpb"
 	"github.com/vizee/jsonpb/jsonlit"
 	"github.com/vizee/jsonpb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var _ engine.CallHandler = &Handler{}
+var (
+	_ engine.CallHandler         = &Handler{}
+	_ engine.ErrorWriter         = &Handler{}
+	_ engine.ServerStreamHandler = &Handler{}
+	_ engine.ClientStreamHandler = &Handler{}
+	_ engine.BidiStreamHandler   = &Handler{}
+)
 
 type Handler struct {
 	SurroundOutput [2]string
 	EmptyRequest   bool
+	StreamFormat   StreamFormat
+	Upgrader       *websocket.Upgrader
+	// MaxLineSize 限制 NDJSON 请求中单行的长度，默认 4MB
+	MaxLineSize int
 }
 
-func (h *Handler) ReadRequest(call *metadata.Call, ctx *engine.Context) ([]byte, error) {
-	data, err := ctx.ReadBody()
-	if err != nil {
-		return nil, err
-	}
+func (h *Handler) encodeRequest(call *metadata.Call, ctx *engine.Context, data []byte) ([]byte, error) {
 	var enc proto.Encoder
 	if len(data) > 0 || !h.EmptyRequest {
 		err := jsonpb.TranscodeToProto(&enc, jsonlit.NewIter(data), call.In)
 		if err != nil {
-			return nil, err
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
 	if len(call.Bindings) > 0 {
-		err = appendBindings(&enc, ctx, call.Bindings)
+		err := appendBindings(&enc, ctx, call.Bindings)
 		if err != nil {
-			return nil, err
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
 	return enc.Bytes(), nil
 }
 
+func (h *Handler) ReadRequest(call *metadata.Call, ctx *engine.Context) ([]byte, error) {
+	data, err := ctx.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	return h.encodeRequest(call, ctx, data)
+}
+
 func (h *Handler) WriteResponse(call *metadata.Call, ctx *engine.Context, data []byte) error {
 	var j jsonpb.JsonBuilder
 	j.AppendString(h.SurroundOutput[0])
@@ -50,3 +71,33 @@ func (h *Handler) WriteResponse(call *metadata.Call, ctx *engine.Context, data [
 	_, err = resp.Write(j.IntoBytes())
 	return err
 }
+
+func appendError(j *jsonpb.JsonBuilder, ctx *engine.Context, err error) {
+	// 非 GRPC 状态的错误可能包含内部信息，只输出状态码描述
+	st, ok := status.FromError(err)
+	msg := st.Message()
+	if !ok {
+		msg = http.StatusText(ctx.ErrorStatus(err))
+	}
+	j.AppendString(`{"code":`)
+	j.AppendString(strconv.Itoa(int(st.Code())))
+	j.AppendString(`,"message":"`)
+	j.AppendEscapedString(msg)
+	j.AppendString(`"}`)
+}
+
+func (*Handler) WriteError(_ *metadata.Call, ctx *engine.Context, err error) error {
+	// 流式响应中的错误已经由 EndStream 输出
+	var se *engine.StreamError
+	if errors.As(err, &se) {
+		return nil
+	}
+	var j jsonpb.JsonBuilder
+	appendError(&j, ctx, err)
+
+	resp := ctx.Response()
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(ctx.ErrorStatus(err))
+	_, err = resp.Write(j.IntoBytes())
+	return err
+}
@@ -0,0 +1,54 @@
+package jsonapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+)
+
+func echoBackend(_ any, stream grpc.ServerStream) error {
+	var req []byte
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+	return stream.SendMsg(encodeText("echo:" + decodeText(req)))
+}
+
+func TestHandler_Unary(t *testing.T) {
+	cc := startBackend(t, echoBackend)
+	e := newTestEngine(t, cc, &Handler{}, &metadata.Route{Method: "POST", Path: "/echo", Call: textCall(metadata.NoStream)}, nil)
+
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/echo", strings.NewReader(`{"text":"hi"}`)))
+	if resp.Code != http.StatusOK || resp.Body.String() != `{"text":"echo:hi"}` {
+		t.Fatalf("response %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/echo", strings.NewReader(`{"text":`)))
+	if resp.Code != http.StatusBadRequest || !strings.HasPrefix(resp.Body.String(), `{"code":3,`) {
+		t.Fatalf("response %d: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestHandler_ErrorHandlerPrecedence(t *testing.T) {
+	cc := startBackend(t, echoBackend)
+	e := newTestEngine(t, cc, &Handler{}, &metadata.Route{Method: "POST", Path: "/echo", Call: textCall(metadata.NoStream)}, func(b *engine.Builder) {
+		b.ErrorHandler(func(ctx *engine.Context, err error) {
+			ctx.Response().WriteHeader(http.StatusTeapot)
+			ctx.Response().Write([]byte("custom"))
+		})
+	})
+
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/echo", strings.NewReader(`{"text":`)))
+	if resp.Code != http.StatusTeapot || resp.Body.String() != "custom" {
+		t.Fatalf("response %d: %s", resp.Code, resp.Body)
+	}
+}
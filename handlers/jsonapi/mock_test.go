@@ -0,0 +1,114 @@
+package jsonapi
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"github.com/vizee/jsonpb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return v.([]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+type connDialer struct {
+	cc *grpc.ClientConn
+}
+
+func (d connDialer) Dial(string) (*grpc.ClientConn, error) {
+	return d.cc, nil
+}
+
+// textMessage 是只有 text 字段的消息，请求和响应都使用它
+var textMessage = func() *jsonpb.Message {
+	msg := &jsonpb.Message{
+		Name:   ".test.Text",
+		Fields: []jsonpb.Field{{Name: "text", Kind: jsonpb.StringKind, Tag: 1}},
+	}
+	msg.BakeTagIndex()
+	msg.BakeNameIndex()
+	return msg
+}()
+
+func encodeText(s string) []byte {
+	var enc proto.Encoder
+	enc.EmitString(1, s)
+	return enc.Bytes()
+}
+
+func decodeText(data []byte) string {
+	dec := proto.NewDecoder(data)
+	for !dec.EOF() {
+		tag, _, _ := dec.ReadTag()
+		s, _ := dec.ReadBytes()
+		if tag == 1 {
+			return string(s)
+		}
+	}
+	return ""
+}
+
+func startBackend(t *testing.T, handler grpc.StreamHandler) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(handler))
+	go srv.Serve(lis)
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Stop()
+	})
+	return cc
+}
+
+func textCall(stream metadata.StreamType) *metadata.Call {
+	return &metadata.Call{
+		Server:  "backend",
+		Handler: "jsonapi",
+		Method:  "/test.Echo/Echo",
+		Stream:  stream,
+		In:      textMessage,
+		Out:     textMessage,
+	}
+}
+
+// newTestEngine 创建只有一条路由的 engine，prepare 可以在构建前修改 Builder
+func newTestEngine(t *testing.T, cc *grpc.ClientConn, h *Handler, route *metadata.Route, prepare func(b *engine.Builder)) *engine.Engine {
+	builder := engine.NewBuilder()
+	builder.Dialer(connDialer{cc: cc})
+	builder.RegisterHandler("jsonapi", h)
+	if prepare != nil {
+		prepare(builder)
+	}
+	e := builder.Build()
+	err := e.RebuildRouter([]*metadata.Route{route}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.ClearRouter)
+	return e
+}
@@ -0,0 +1,157 @@
+package jsonapi
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/log"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"github.com/vizee/jsonpb/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type StreamFormat uint32
+
+const (
+	// StreamAuto 根据 Accept 头部选择 SSE，否则输出 NDJSON
+	StreamAuto StreamFormat = iota
+	StreamNDJSON
+	StreamSSE
+)
+
+func (h *Handler) streamFormat(ctx *engine.Context) StreamFormat {
+	if h.StreamFormat != StreamAuto {
+		return h.StreamFormat
+	}
+	if strings.Contains(ctx.Request().Header.Get("Accept"), "text/event-stream") {
+		return StreamSSE
+	}
+	return StreamNDJSON
+}
+
+func flush(w http.ResponseWriter) error {
+	err := http.NewResponseController(w).Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+func (h *Handler) BeginStream(_ *metadata.Call, ctx *engine.Context) error {
+	resp := ctx.Response()
+	if h.streamFormat(ctx) == StreamSSE {
+		resp.Header().Set("Content-Type", "text/event-stream")
+		resp.Header().Set("Cache-Control", "no-cache")
+	} else {
+		resp.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	resp.WriteHeader(http.StatusOK)
+	return flush(resp)
+}
+
+func (h *Handler) WriteMessage(call *metadata.Call, ctx *engine.Context, data []byte) error {
+	sse := h.streamFormat(ctx) == StreamSSE
+	var j jsonpb.JsonBuilder
+	if sse {
+		j.AppendString("data: ")
+	}
+	j.AppendString(h.SurroundOutput[0])
+	err := jsonpb.TranscodeToJson(&j, proto.NewDecoder(data), call.Out)
+	if err != nil {
+		return err
+	}
+	j.AppendString(h.SurroundOutput[1])
+	if sse {
+		j.AppendString("\n\n")
+	} else {
+		j.AppendByte('\n')
+	}
+
+	resp := ctx.Response()
+	_, err = resp.Write(j.IntoBytes())
+	if err != nil {
+		return err
+	}
+	return flush(resp)
+}
+
+// EndStream 把错误写入流中，然后返回原来的错误，让 engine 记录调用失败
+func (h *Handler) EndStream(_ *metadata.Call, ctx *engine.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var j jsonpb.JsonBuilder
+	if h.streamFormat(ctx) == StreamSSE {
+		j.AppendString("event: error\ndata: ")
+		appendError(&j, ctx, err)
+		j.AppendString("\n\n")
+	} else {
+		j.AppendString(`{"error":`)
+		appendError(&j, ctx, err)
+		j.AppendString("}\n")
+	}
+
+	resp := ctx.Response()
+	_, werr := resp.Write(j.IntoBytes())
+	if werr == nil {
+		werr = flush(resp)
+	}
+	if werr != nil {
+		log.Debugf("EndStream %s: %v", ctx.Request().URL.Path, werr)
+	}
+	return err
+}
+
+const defaultMaxLineSize = 4 << 20
+
+// readLine 读取一行，长度超过 maxSize 时返回错误而不是继续缓存
+func readLine(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(bytes.TrimRight(chunk, "\r\n")) > maxSize {
+			return nil, status.Errorf(codes.InvalidArgument, "line size exceeds limit %d", maxSize)
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// ReadStream 把 NDJSON 格式的请求体逐行转换为请求消息，空行会被跳过
+func (h *Handler) ReadStream(call *metadata.Call, ctx *engine.Context, send func(data []byte) error) error {
+	maxSize := h.MaxLineSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxLineSize
+	}
+	r := bufio.NewReader(ctx.Request().Body)
+	for {
+		line, err := readLine(r, maxSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			data, err := h.encodeRequest(call, ctx, line)
+			if err != nil {
+				return err
+			}
+			err = send(data)
+			if err != nil {
+				return err
+			}
+		}
+		if eof {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package jsonapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// countBackend 返回 1 和 2 两条消息，请求为 fail 时之后返回错误
+func countBackend(_ any, stream grpc.ServerStream) error {
+	var req []byte
+	err := stream.RecvMsg(&req)
+	if err != nil {
+		return err
+	}
+	for _, s := range []string{"1", "2"} {
+		err = stream.SendMsg(encodeText(s))
+		if err != nil {
+			return err
+		}
+	}
+	if decodeText(req) == "fail" {
+		return status.Error(codes.Unavailable, "gone")
+	}
+	return nil
+}
+
+func TestHandler_ServerStream(t *testing.T) {
+	cc := startBackend(t, countBackend)
+	var handled error
+	e := newTestEngine(t, cc, &Handler{}, &metadata.Route{Method: "POST", Path: "/count", Call: textCall(metadata.ServerStream)}, func(b *engine.Builder) {
+		b.ErrorHandler(func(ctx *engine.Context, err error) {
+			handled = err
+		})
+	})
+	serve := func(text string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/count", strings.NewReader(`{"text":"`+text+`"}`))
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp := httptest.NewRecorder()
+		handled = nil
+		e.ServeHTTP(resp, req)
+		return resp
+	}
+
+	tests := []struct {
+		name        string
+		text        string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"ndjson", "ok", "", "application/x-ndjson", "{\"text\":\"1\"}\n{\"text\":\"2\"}\n"},
+		{"sse", "ok", "text/event-stream", "text/event-stream", "data: {\"text\":\"1\"}\n\ndata: {\"text\":\"2\"}\n\n"},
+		{"ndjson_error", "fail", "", "application/x-ndjson",
+			"{\"text\":\"1\"}\n{\"text\":\"2\"}\n{\"error\":{\"code\":14,\"message\":\"gone\"}}\n"},
+		{"sse_error", "fail", "text/event-stream", "text/event-stream",
+			"data: {\"text\":\"1\"}\n\ndata: {\"text\":\"2\"}\n\nevent: error\ndata: {\"code\":14,\"message\":\"gone\"}\n\n"},
+	}
+	for _, tt := range tests {
+		resp := serve(tt.text, tt.accept)
+		if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != tt.contentType || resp.Body.String() != tt.body {
+			t.Fatalf("%s: response %d %q: %q", tt.name, resp.Code, resp.Header().Get("Content-Type"), resp.Body)
+		}
+		// 流中的错误仍然交给错误处理
+		var se *engine.StreamError
+		if tt.text == "fail" {
+			if !errors.As(handled, &se) || status.Code(handled) != codes.Unavailable {
+				t.Fatalf("%s: handled %v", tt.name, handled)
+			}
+		} else if handled != nil {
+			t.Fatalf("%s: handled %v", tt.name, handled)
+		}
+	}
+}
+
+func TestHandler_ServerStreamDefaultError(t *testing.T) {
+	cc := startBackend(t, countBackend)
+	e := newTestEngine(t, cc, &Handler{}, &metadata.Route{Method: "POST", Path: "/count", Call: textCall(metadata.ServerStream)}, nil)
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, httptest.NewRequest("POST", "/count", strings.NewReader(`{"text":"fail"}`)))
+	// WriteError 不会在流后面再输出错误
+	if resp.Code != http.StatusOK || resp.Body.String() != "{\"text\":\"1\"}\n{\"text\":\"2\"}\n{\"error\":{\"code\":14,\"message\":\"gone\"}}\n" {
+		t.Fatalf("response %d: %q", resp.Code, resp.Body)
+	}
+}
+
+// joinBackend 把收到的 text 用逗号连接后返回
+func joinBackend(_ any, stream grpc.ServerStream) error {
+	var texts []string
+	for {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		texts = append(texts, decodeText(req))
+	}
+	return stream.SendMsg(encodeText(strings.Join(texts, ",")))
+}
+
+func TestHandler_ReadStream(t *testing.T) {
+	cc := startBackend(t, joinBackend)
+	e := newTestEngine(t, cc, &Handler{MaxLineSize: 16}, &metadata.Route{Method: "POST", Path: "/join", Call: textCall(metadata.ClientStream)}, nil)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		resp string
+	}{
+		{"valid", "{\"text\":\"a\"}\n\n {\"text\":\"b\"}\r\n{\"text\":\"c\"}", http.StatusOK, `{"text":"a,b,c"}`},
+		{"empty", "", http.StatusOK, `{"text":""}`},
+		{"truncated", "{\"text\":\"a\"}\n{\"text\":\"b", http.StatusBadRequest, ""},
+		{"oversize", "{\"text\":\"a\"}\n{\"text\":\"abcdefg\"}\n", http.StatusBadRequest, ""},
+		{"malformed", "{\"text\":\"a\"}\nnot json\n{\"text\":\"b\"}\n", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		e.ServeHTTP(resp, httptest.NewRequest("POST", "/join", strings.NewReader(tt.body)))
+		if resp.Code != tt.code || (tt.resp != "" && resp.Body.String() != tt.resp) {
+			t.Fatalf("%s: response %d: %q", tt.name, resp.Code, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package jsonapi
+
+import (
+	"io"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/log"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"github.com/vizee/jsonpb/jsonlit"
+	"github.com/vizee/jsonpb/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	closeWriteTimeout = time.Second
+	// 控制帧的 payload 最多 125 字节，除去 2 字节的关闭码
+	maxCloseReason = 123
+)
+
+var defaultUpgrader = &websocket.Upgrader{}
+
+func closeMessage(err error) []byte {
+	if err == io.EOF {
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	}
+	reason := "internal error"
+	st, ok := status.FromError(err)
+	if ok {
+		reason = st.Code().String() + ": " + st.Message()
+	}
+	if len(reason) > maxCloseReason {
+		// 截断到字符边界，关闭原因必须是合法的 UTF-8
+		n := maxCloseReason
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	return websocket.FormatCloseMessage(websocket.CloseInternalServerErr, reason)
+}
+
+func (h *Handler) pumpResponses(call *metadata.Call, conn *websocket.Conn, stream grpc.ClientStream, done chan struct{}) {
+	defer close(done)
+	for {
+		var data []byte
+		err := stream.RecvMsg(&data)
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage, closeMessage(err), time.Now().Add(closeWriteTimeout))
+			// 关闭连接让读取端退出
+			conn.Close()
+			return
+		}
+		var j jsonpb.JsonBuilder
+		j.AppendString(h.SurroundOutput[0])
+		err = jsonpb.TranscodeToJson(&j, proto.NewDecoder(data), call.Out)
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "internal error"), time.Now().Add(closeWriteTimeout))
+			conn.Close()
+			return
+		}
+		j.AppendString(h.SurroundOutput[1])
+		err = conn.WriteMessage(websocket.TextMessage, j.IntoBytes())
+		if err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// ServeStream 把连接升级为 WebSocket，每个数据帧都作为一条 JSON 消息收发。
+// Bindings 只在升级前提取一次，并附加到每条请求消息中
+func (h *Handler) ServeStream(call *metadata.Call, ctx *engine.Context, stream grpc.ClientStream) error {
+	var bindings []byte
+	if len(call.Bindings) > 0 {
+		var enc proto.Encoder
+		err := appendBindings(&enc, ctx, call.Bindings)
+		if err != nil {
+			return err
+		}
+		bindings = enc.Bytes()
+	}
+
+	upgrader := h.Upgrader
+	if upgrader == nil {
+		upgrader = defaultUpgrader
+	}
+	// 升级失败时 Upgrader 已经输出了错误响应
+	conn, err := upgrader.Upgrade(ctx.Response(), ctx.Request(), nil)
+	if err != nil {
+		log.Warnf("Upgrade %s: %v", ctx.Request().URL.Path, err)
+		return nil
+	}
+	defer conn.Close()
+	// 收到客户端的关闭帧后不立即回复，等 pumpResponses 输出剩余的响应和最终状态后再关闭
+	conn.SetCloseHandler(func(int, string) error {
+		return nil
+	})
+
+	// 之后 ctx 不能在其他 goroutine 中使用，Handler 返回后 ctx 会被回收
+	done := make(chan struct{})
+	go h.pumpResponses(call, conn, stream, done)
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				// 客户端正常关闭时半关闭调用，避免返回后取消调用
+				stream.CloseSend()
+				select {
+				case <-done:
+				case <-stream.Context().Done():
+				}
+				return nil
+			}
+			log.Debugf("ReadMessage %s: %v", call.Method, err)
+			return nil
+		}
+		var enc proto.Encoder
+		err = jsonpb.TranscodeToProto(&enc, jsonlit.NewIter(data), call.In)
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, "invalid message"), time.Now().Add(closeWriteTimeout))
+			return nil
+		}
+		enc.WriteBytes(bindings)
+		err = stream.SendMsg(enc.Bytes())
+		if err != nil {
+			// 服务端已经结束调用，等待 pumpResponses 输出最终状态
+			<-done
+			return nil
+		}
+	}
+}
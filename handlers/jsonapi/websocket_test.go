@@ -0,0 +1,146 @@
+package jsonapi
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCloseMessage(t *testing.T) {
+	msg := closeMessage(status.Error(codes.Internal, strings.Repeat("é", 100)))
+	reason := string(msg[2:])
+	if len(reason) > maxCloseReason || !utf8.ValidString(reason) || !strings.HasPrefix(reason, "Internal: é") {
+		t.Fatalf("reason %q", reason)
+	}
+}
+
+// chatBackend 逐条回复 echo:text，收到 end 时结束调用，收到 fail 时返回错误。
+// 调用结束时把 stream 的错误发送到 done
+func chatBackend(done chan<- error) grpc.StreamHandler {
+	return func(_ any, stream grpc.ServerStream) error {
+		for {
+			var req []byte
+			err := stream.RecvMsg(&req)
+			if err == io.EOF {
+				done <- nil
+				return nil
+			} else if err != nil {
+				done <- err
+				return err
+			}
+			switch text := decodeText(req); text {
+			case "end":
+				done <- nil
+				return nil
+			case "fail":
+				done <- nil
+				return status.Error(codes.Unavailable, "gone")
+			default:
+				err = stream.SendMsg(encodeText("echo:" + text))
+				if err != nil {
+					done <- err
+					return err
+				}
+			}
+		}
+	}
+}
+
+func dialChat(t *testing.T) (*websocket.Conn, chan error) {
+	done := make(chan error, 1)
+	cc := startBackend(t, chatBackend(done))
+	e := newTestEngine(t, cc, &Handler{}, &metadata.Route{Method: "GET", Path: "/chat", Call: textCall(metadata.BidiStream)}, nil)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, done
+}
+
+func chat(t *testing.T, conn *websocket.Conn, text string) {
+	err := conn.WriteMessage(websocket.TextMessage, []byte(`{"text":"`+text+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil || string(data) != `{"text":"echo:`+text+`"}` {
+		t.Fatalf("read %q: %v", data, err)
+	}
+}
+
+func expectClose(t *testing.T, conn *websocket.Conn, code int, text string) {
+	_, _, err := conn.ReadMessage()
+	ce, ok := err.(*websocket.CloseError)
+	if !ok || ce.Code != code || ce.Text != text {
+		t.Fatalf("close %v", err)
+	}
+}
+
+func waitBackend(t *testing.T, done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend not finished")
+		return nil
+	}
+}
+
+func TestHandler_WebSocketComplete(t *testing.T) {
+	conn, done := dialChat(t)
+	chat(t, conn, "a")
+	err := conn.WriteMessage(websocket.TextMessage, []byte(`{"text":"end"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, conn, websocket.CloseNormalClosure, "")
+	if err := waitBackend(t, done); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandler_WebSocketClientClose(t *testing.T) {
+	conn, done := dialChat(t)
+	chat(t, conn, "a")
+	// 正常关闭时后端收到 EOF，之前的响应仍然能送达
+	err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitBackend(t, done); err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, conn, websocket.CloseNormalClosure, "")
+
+	// 连接直接断开时调用被取消
+	conn, done = dialChat(t)
+	chat(t, conn, "b")
+	conn.Close()
+	if err := waitBackend(t, done); status.Code(err) != codes.Canceled {
+		t.Fatalf("backend %v", err)
+	}
+}
+
+func TestHandler_WebSocketBackendError(t *testing.T) {
+	conn, done := dialChat(t)
+	chat(t, conn, "a")
+	err := conn.WriteMessage(websocket.TextMessage, []byte(`{"text":"fail"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectClose(t, conn, websocket.CloseInternalServerErr, "Unavailable: gone")
+	waitBackend(t, done)
+}
@@ -1,13 +1,24 @@
 package passthrough
 
 import (
+	"encoding/binary"
+	"io"
+
 	"github.com/vizee/gapi/engine"
 	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var _ engine.CallHandler = &Handler{}
+const defaultMaxMessageSize = 4 << 20
+
+var (
+	_ engine.CallHandler         = &Handler{}
+	_ engine.ClientStreamHandler = &Handler{}
+)
 
 type Handler struct {
+	MaxMessageSize uint32
 }
 
 func (*Handler) ReadRequest(_ *metadata.Call, ctx *engine.Context) ([]byte, error) {
@@ -18,3 +29,46 @@ func (*Handler) WriteResponse(call *metadata.Call, ctx *engine.Context, data []b
 	_, err := ctx.Response().Write(data)
 	return err
 }
+
+// truncated 把不完整的消息作为客户端错误返回
+func truncated(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return status.Error(codes.InvalidArgument, "truncated message")
+	}
+	return err
+}
+
+// ReadStream 读取以 4 字节大端长度为前缀的消息序列
+func (h *Handler) ReadStream(_ *metadata.Call, ctx *engine.Context, send func(data []byte) error) error {
+	maxSize := h.MaxMessageSize
+	if maxSize == 0 {
+		maxSize = defaultMaxMessageSize
+	}
+	body := ctx.Request().Body
+	var hdr [4]byte
+	for {
+		_, err := io.ReadFull(body, hdr[:])
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return truncated(err)
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n > maxSize {
+			return status.Errorf(codes.InvalidArgument, "message size %d exceeds limit %d", n, maxSize)
+		}
+		data := make([]byte, n)
+		_, err = io.ReadFull(body, data)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return truncated(err)
+		}
+		err = send(data)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package passthrough
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	return v.([]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "raw"
+}
+
+type connDialer struct {
+	cc *grpc.ClientConn
+}
+
+func (d connDialer) Dial(string) (*grpc.ClientConn, error) {
+	return d.cc, nil
+}
+
+// joinHandler 把收到的消息用逗号连接后返回
+func joinHandler(_ any, stream grpc.ServerStream) error {
+	var msgs [][]byte
+	for {
+		var req []byte
+		err := stream.RecvMsg(&req)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		msgs = append(msgs, req)
+	}
+	return stream.SendMsg(bytes.Join(msgs, []byte(",")))
+}
+
+func newTestEngine(t *testing.T, h *Handler) *engine.Engine {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(joinHandler))
+	go srv.Serve(lis)
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Stop()
+	})
+
+	builder := engine.NewBuilder()
+	builder.Dialer(connDialer{cc: cc})
+	builder.RegisterHandler("passthrough", h)
+	e := builder.Build()
+	err = e.RebuildRouter([]*metadata.Route{
+		{Method: "POST", Path: "/join", Call: &metadata.Call{Server: "join", Handler: "passthrough", Method: "/test.Join/Join", Stream: metadata.ClientStream}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func frames(msgs ...string) []byte {
+	var buf []byte
+	for _, msg := range msgs {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg)))
+		buf = append(buf, msg...)
+	}
+	return buf
+}
+
+func TestHandler_ReadStream(t *testing.T) {
+	e := newTestEngine(t, &Handler{MaxMessageSize: 8})
+
+	tests := []struct {
+		name string
+		body []byte
+		code int
+		resp string
+	}{
+		{"valid", frames("a", "", "bc"), http.StatusOK, "a,,bc"},
+		{"empty", nil, http.StatusOK, ""},
+		{"truncated_header", append(frames("a"), 0, 0), http.StatusBadRequest, ""},
+		{"truncated_data", frames("a", "bc")[:9], http.StatusBadRequest, ""},
+		{"oversize", frames("a", "123456789"), http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		e.ServeHTTP(resp, httptest.NewRequest("POST", "/join", bytes.NewReader(tt.body)))
+		if resp.Code != tt.code || (tt.code == http.StatusOK && resp.Body.String() != tt.resp) {
+			t.Fatalf("%s: response %d: %q", tt.name, resp.Code, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+type BindingConfig struct {
+	Field string `json:"field" yaml:"field"`
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"` // 默认与 Field 相同
+	From  string `json:"from" yaml:"from"`
+}
+
+type ForwardConfig struct {
+	Name   string `json:"name" yaml:"name"`
+	Prefix bool   `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Rename string `json:"rename,omitempty" yaml:"rename,omitempty"`
+	From   string `json:"from" yaml:"from"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int      `json:"max_attempts" yaml:"max_attempts"`
+	Codes          []string `json:"codes,omitempty" yaml:"codes,omitempty"` // 例如 UNAVAILABLE
+	InitialBackoff string   `json:"initial_backoff,omitempty" yaml:"initial_backoff,omitempty"`
+	MaxBackoff     string   `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty"`
+	Multiplier     float64  `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	PerTryTimeout  string   `json:"per_try_timeout,omitempty" yaml:"per_try_timeout,omitempty"`
+}
+
+type HedgeConfig struct {
+	Delay       string `json:"delay" yaml:"delay"`
+	MaxAttempts int    `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty"`
+	Server      string `json:"server,omitempty" yaml:"server,omitempty"`
+}
+
+// SplitTargetConfig 是灰度目标，沿用路由的调用配置，但不使用路由的 fallback，hedge 的额外调用也发往目标自己
+type SplitTargetConfig struct {
+	Server string   `json:"server" yaml:"server"`
+	Weight uint32   `json:"weight" yaml:"weight"`
+	Pin    []string `json:"pin,omitempty" yaml:"pin,omitempty"`
+}
+
+type SplitConfig struct {
+	Weight  uint32              `json:"weight" yaml:"weight"` // 路由本身的权重
+	Pin     []string            `json:"pin,omitempty" yaml:"pin,omitempty"`
+	Header  string              `json:"header,omitempty" yaml:"header,omitempty"`
+	Cookie  string              `json:"cookie,omitempty" yaml:"cookie,omitempty"`
+	Targets []SplitTargetConfig `json:"targets" yaml:"targets"`
+}
+
+type RouteConfig struct {
+	Method   string          `json:"method" yaml:"method"`
+	Path     string          `json:"path" yaml:"path"`
+	Use      []string        `json:"use,omitempty" yaml:"use,omitempty"`
+	Server   string          `json:"server" yaml:"server"`
+	Fallback string          `json:"fallback,omitempty" yaml:"fallback,omitempty"`
+	Handler  string          `json:"handler" yaml:"handler"`
+	Call     string          `json:"call" yaml:"call"`                         // GRPC 方法全名，例如 /pkg.Service/Method
+	Stream   string          `json:"stream,omitempty" yaml:"stream,omitempty"` // server、client 或 bidi
+	Input    string          `json:"input,omitempty" yaml:"input,omitempty"`   // 为空时使用方法描述中的类型
+	Output   string          `json:"output,omitempty" yaml:"output,omitempty"` // 同 Input
+	Timeout  string          `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Bindings []BindingConfig `json:"bindings,omitempty" yaml:"bindings,omitempty"`
+	Forward  []ForwardConfig `json:"forward,omitempty" yaml:"forward,omitempty"`
+	Returns  []string        `json:"returns,omitempty" yaml:"returns,omitempty"`
+	Split    *SplitConfig    `json:"split,omitempty" yaml:"split,omitempty"`
+
+	Idempotent bool         `json:"idempotent,omitempty" yaml:"idempotent,omitempty"`
+	Retry      *RetryConfig `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Hedge      *HedgeConfig `json:"hedge,omitempty" yaml:"hedge,omitempty"`
+}
+
+type Config struct {
+	Routes []RouteConfig `json:"routes" yaml:"routes"`
+}
+
+type Resolver interface {
+	FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+type RouteError struct {
+	Index  int
+	Method string
+	Path   string
+	Err    error
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("route #%d %s %s: %v", e.Index, e.Method, e.Path, e.Err)
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+var bindSources = map[string]metadata.BindSource{
+	"query":   metadata.BindQuery,
+	"params":  metadata.BindParams,
+	"header":  metadata.BindHeader,
+	"context": metadata.BindContext,
+}
+
+var streamTypes = map[string]metadata.StreamType{
+	"":       metadata.NoStream,
+	"server": metadata.ServerStream,
+	"client": metadata.ClientStream,
+	"bidi":   metadata.BidiStream,
+}
+
+func findMessage(resolver Resolver, name string) (protoreflect.MessageDescriptor, error) {
+	desc, err := resolver.FindDescriptorByName(protoreflect.FullName(strings.TrimPrefix(name, ".")))
+	if err != nil {
+		return nil, fmt.Errorf("message %s: %w", name, err)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return md, nil
+}
+
+func findMethod(resolver Resolver, call string) (protoreflect.MethodDescriptor, error) {
+	name := strings.TrimPrefix(call, "/")
+	i := strings.LastIndexByte(name, '/')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid call %q", call)
+	}
+	desc, err := resolver.FindDescriptorByName(protoreflect.FullName(name[:i] + "." + name[i+1:]))
+	if err != nil {
+		return nil, err
+	}
+	md, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", call)
+	}
+	return md, nil
+}
+
+// boundMessage 复制 msg 并把绑定的字段标记为 OmitAlways，避免影响其他路由共享的消息
+func boundMessage(msg *jsonpb.Message, bindings []metadata.FieldBinding) *jsonpb.Message {
+	fields := append([]jsonpb.Field(nil), msg.Fields...)
+	for _, b := range bindings {
+		for i := range fields {
+			if fields[i].Tag == b.Tag {
+				fields[i].Omit = jsonpb.OmitAlways
+			}
+		}
+	}
+	return jsonpb.NewMessage(msg.Name, fields, true, true)
+}
+
+func (l *Loader) configRoute(rc *RouteConfig, resolver Resolver) (*metadata.Route, error) {
+	if rc.Method == "" {
+		return nil, errors.New("method not specified")
+	}
+	if rc.Path == "" || rc.Path[0] != '/' {
+		return nil, fmt.Errorf("invalid path %q", rc.Path)
+	}
+	if rc.Server == "" {
+		return nil, errors.New("server not specified")
+	}
+	if rc.Handler == "" {
+		return nil, errors.New("handler not specified")
+	}
+	if rc.Call == "" {
+		return nil, errors.New("call not specified")
+	}
+	stream, ok := streamTypes[rc.Stream]
+	if !ok {
+		return nil, fmt.Errorf("unknown stream type %q", rc.Stream)
+	}
+	timeout, err := parseDuration("timeout", rc.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := retryConfig(rc.Retry)
+	if err != nil {
+		return nil, err
+	}
+	var hedge *metadata.HedgePolicy
+	if rc.Hedge != nil {
+		if rc.Hedge.Delay == "" {
+			return nil, errors.New("hedge delay not specified")
+		}
+		delay, err := parseDuration("hedge delay", rc.Hedge.Delay)
+		if err != nil {
+			return nil, err
+		}
+		hedge = &metadata.HedgePolicy{
+			Delay:       delay,
+			MaxAttempts: rc.Hedge.MaxAttempts,
+			Server:      rc.Hedge.Server,
+		}
+	}
+
+	var inDesc, outDesc protoreflect.MessageDescriptor
+	if rc.Input == "" || rc.Output == "" {
+		md, err := findMethod(resolver, rc.Call)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: %w", rc.Call, err)
+		}
+		inDesc, outDesc = md.Input(), md.Output()
+		if rc.Stream == "" {
+			stream = streamType(md)
+		}
+	}
+	if rc.Input != "" {
+		var err error
+		inDesc, err = findMessage(resolver, rc.Input)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rc.Output != "" {
+		var err error
+		outDesc, err = findMessage(resolver, rc.Output)
+		if err != nil {
+			return nil, err
+		}
+	}
+	in, err := l.Message(inDesc)
+	if err != nil {
+		return nil, err
+	}
+	out, err := l.Message(outDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	// 配置中的 bindings 合并到注解的 bindings 中，同一字段以配置为准
+	bindings, err := fieldBindings(inDesc)
+	if err != nil {
+		return nil, err
+	}
+	for _, bc := range rc.Bindings {
+		source, ok := bindSources[bc.From]
+		if !ok {
+			return nil, fmt.Errorf("binding %s: unknown source %q", bc.Field, bc.From)
+		}
+		fd := inDesc.Fields().ByName(protoreflect.Name(bc.Field))
+		if fd == nil {
+			return nil, fmt.Errorf("binding %s: no such field in %s", bc.Field, inDesc.FullName())
+		}
+		kind, err := bindingKind(fd)
+		if err != nil {
+			return nil, err
+		}
+		name := bc.Name
+		if name == "" {
+			name = bc.Field
+		}
+		fb := metadata.FieldBinding{
+			Name: name,
+			Kind: kind,
+			Tag:  uint32(fd.Number()),
+			Bind: source,
+		}
+		replaced := false
+		for i := range bindings {
+			if bindings[i].Tag == fb.Tag {
+				bindings[i] = fb
+				replaced = true
+			}
+		}
+		if !replaced {
+			bindings = append(bindings, fb)
+		}
+	}
+	if len(rc.Bindings) > 0 {
+		in = boundMessage(in, bindings)
+	}
+
+	var forward []metadata.ForwardRule
+	for _, fc := range rc.Forward {
+		source, ok := bindSources[fc.From]
+		if !ok || (source != metadata.BindHeader && source != metadata.BindContext) {
+			return nil, fmt.Errorf("forward %s: unsupported source %q", fc.Name, fc.From)
+		}
+		forward = append(forward, metadata.ForwardRule{
+			Name:   fc.Name,
+			Prefix: fc.Prefix,
+			Rename: fc.Rename,
+			Source: source,
+		})
+	}
+
+	call := &metadata.Call{
+		Server:   rc.Server,
+		Fallback: rc.Fallback,
+		Handler:  rc.Handler,
+		Method:   "/" + strings.TrimPrefix(rc.Call, "/"),
+		Stream:   stream,
+		In:       in,
+		Out:      out,
+		Bindings: bindings,
+		Forward:  forward,
+		Returns:  rc.Returns,
+		Timeout:  timeout,
+
+		Idempotent: rc.Idempotent,
+		Retry:      retry,
+		Hedge:      hedge,
+	}
+	split, err := splitConfig(rc.Split, call)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.Route{
+		Method: rc.Method,
+		Path:   rc.Path,
+		Use:    rc.Use,
+		Call:   call,
+		Split:  split,
+	}, nil
+}
+
+func parseDuration(name string, s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s %s", name, s)
+	}
+	return d, nil
+}
+
+func retryConfig(rc *RetryConfig) (*metadata.RetryPolicy, error) {
+	if rc == nil {
+		return nil, nil
+	}
+	policy := &metadata.RetryPolicy{
+		MaxAttempts:       rc.MaxAttempts,
+		BackoffMultiplier: rc.Multiplier,
+	}
+	for _, name := range rc.Codes {
+		var code codes.Code
+		err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name))))
+		if err != nil {
+			return nil, fmt.Errorf("retry: %w", err)
+		}
+		policy.Codes = append(policy.Codes, code)
+	}
+	var err error
+	policy.InitialBackoff, err = parseDuration("initial_backoff", rc.InitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+	policy.MaxBackoff, err = parseDuration("max_backoff", rc.MaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	policy.PerTryTimeout, err = parseDuration("per_try_timeout", rc.PerTryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func splitConfig(sc *SplitConfig, call *metadata.Call) (*metadata.Split, error) {
+	if sc == nil {
+		return nil, nil
+	}
+	split := &metadata.Split{
+		Weight: sc.Weight,
+		Pin:    sc.Pin,
+		Header: sc.Header,
+		Cookie: sc.Cookie,
+	}
+	total := sc.Weight
+	for i := range sc.Targets {
+		tc := &sc.Targets[i]
+		if tc.Server == "" {
+			return nil, fmt.Errorf("split target #%d: server not specified", i)
+		}
+		// Fallback 和 Hedge.Server 指向路由本身的备用 server，不能带到灰度目标上
+		target := *call
+		target.Server = tc.Server
+		target.Fallback = ""
+		if call.Hedge != nil && call.Hedge.Server != "" {
+			hedge := *call.Hedge
+			hedge.Server = ""
+			target.Hedge = &hedge
+		}
+		split.Targets = append(split.Targets, metadata.SplitTarget{
+			Call:   &target,
+			Weight: tc.Weight,
+			Pin:    tc.Pin,
+		})
+		total += tc.Weight
+	}
+	if total == 0 {
+		return nil, errors.New("split has no weight")
+	}
+	return split, nil
+}
+
+// ConfigRoutes 使用 resolver 解析配置中引用的类型，出错的路由会被跳过，错误以 RouteError 的形式合并返回
+func (l *Loader) ConfigRoutes(cfg *Config, resolver Resolver) ([]*metadata.Route, error) {
+	var (
+		routes []*metadata.Route
+		errs   []error
+	)
+	for i := range cfg.Routes {
+		rc := &cfg.Routes[i]
+		route, err := l.configRoute(rc, resolver)
+		if err != nil {
+			errs = append(errs, &RouteError{Index: i, Method: rc.Method, Path: rc.Path, Err: err})
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, errors.Join(errs...)
+}
+
+// ParseConfig 按文件扩展名解析配置，.json 使用 JSON，其他都按 YAML 解析
+func ParseConfig(name string, data []byte) (*Config, error) {
+	var cfg Config
+	var err error
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadConfigFile 读取配置文件并生成路由，resolver 通常是 protoregistry.GlobalFiles 或者从描述集构建的 protoregistry.Files
+func LoadConfigFile(name string, resolver Resolver) ([]*metadata.Route, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(name, data)
+	if err != nil {
+		return nil, err
+	}
+	return NewLoader().ConfigRoutes(cfg, resolver)
+}
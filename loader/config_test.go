@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+const mockConfig = `
+routes:
+  - method: POST
+    path: /add
+    use: [auth]
+    server: localhost:50051
+    handler: jsonapi
+    call: /pdtest.Calc/Add
+    timeout: 2s
+    bindings:
+      - field: a
+        name: x
+        from: query
+    forward:
+      - name: X-Trace-
+        prefix: true
+        from: header
+    idempotent: true
+    retry:
+      max_attempts: 3
+      codes: [unavailable, RESOURCE_EXHAUSTED]
+      initial_backoff: 50ms
+      per_try_timeout: 1s
+    split:
+      weight: 95
+      pin: [v1]
+      header: X-Canary
+      targets:
+        - server: localhost:50052
+          weight: 5
+          pin: [v2]
+  - method: GET
+    path: /sum
+    server: localhost:50051
+    handler: jsonapi
+    call: /pdtest.Calc/Sum
+    input: pdtest.AddResponse
+    output: .pdtest.AddResponse
+    idempotent: true
+    hedge:
+      delay: 20ms
+      server: localhost:50052
+  - method: GET
+    path: /missing
+    server: localhost:50051
+    handler: jsonapi
+    call: /pdtest.Calc/Missing
+`
+
+func TestLoader_ConfigRoutes(t *testing.T) {
+	files, err := protodesc.NewFiles(mockFileDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig("routes.yaml", []byte(mockConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := NewLoader().ConfigRoutes(cfg, files)
+	var re *RouteError
+	if !errors.As(err, &re) || re.Index != 2 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("routes: %d", len(routes))
+	}
+
+	call := routes[0].Call
+	if call.Method != "/pdtest.Calc/Add" || call.Timeout != 2*time.Second || call.In.Name != ".pdtest.AddRequest" || call.Out.Name != ".pdtest.AddResponse" {
+		t.Fatalf("call: %+v", call)
+	}
+	// uid 来自注解，a 来自配置
+	if len(call.Bindings) != 2 || call.Bindings[0] != (metadata.FieldBinding{Name: "uid", Kind: jsonpb.Int64Kind, Tag: 3, Bind: metadata.BindContext}) ||
+		call.Bindings[1] != (metadata.FieldBinding{Name: "x", Kind: jsonpb.Int32Kind, Tag: 1, Bind: metadata.BindQuery}) {
+		t.Fatalf("bindings: %+v", call.Bindings)
+	}
+	if f := call.In.FieldByName("a"); f == nil || f.Omit != jsonpb.OmitAlways {
+		t.Fatalf("a: %+v", f)
+	}
+	if len(call.Forward) != 1 || !call.Forward[0].Prefix || call.Forward[0].Source != metadata.BindHeader {
+		t.Fatalf("forward: %+v", call.Forward)
+	}
+	if !call.Idempotent || call.Retry == nil || call.Retry.MaxAttempts != 3 || call.Retry.InitialBackoff != 50*time.Millisecond || call.Retry.PerTryTimeout != time.Second {
+		t.Fatalf("retry: %+v", call.Retry)
+	}
+	if len(call.Retry.Codes) != 2 || call.Retry.Codes[0] != codes.Unavailable || call.Retry.Codes[1] != codes.ResourceExhausted {
+		t.Fatalf("retry codes: %v", call.Retry.Codes)
+	}
+	split := routes[0].Split
+	if split == nil || split.Weight != 95 || len(split.Pin) != 1 || split.Pin[0] != "v1" || split.Header != "X-Canary" || len(split.Targets) != 1 {
+		t.Fatalf("split: %+v", split)
+	}
+	if target := split.Targets[0]; target.Weight != 5 || len(target.Pin) != 1 || target.Call.Server != "localhost:50052" || target.Call.Method != call.Method || call.Server != "localhost:50051" {
+		t.Fatalf("split target: %+v", target)
+	}
+	if hedge := routes[1].Call.Hedge; hedge == nil || hedge.Delay != 20*time.Millisecond || hedge.Server != "localhost:50052" {
+		t.Fatalf("hedge: %+v", hedge)
+	}
+	if routes[1].Call.In != routes[1].Call.Out {
+		t.Fatal("messages should be shared")
+	}
+}
+
+func TestLoader_ConfigSplitHedge(t *testing.T) {
+	files, err := protodesc.NewFiles(mockFileDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig("routes.yaml", []byte(`
+routes:
+  - method: POST
+    path: /add
+    server: localhost:50051
+    fallback: localhost:50053
+    handler: jsonapi
+    call: /pdtest.Calc/Add
+    idempotent: true
+    hedge:
+      delay: 10ms
+      server: localhost:50054
+    split:
+      weight: 1
+      targets:
+        - server: localhost:50052
+          weight: 1
+  - method: POST
+    path: /nodelay
+    server: localhost:50051
+    handler: jsonapi
+    call: /pdtest.Calc/Add
+    hedge:
+      server: localhost:50054
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := NewLoader().ConfigRoutes(cfg, files)
+	var re *RouteError
+	if !errors.As(err, &re) || re.Index != 1 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 1 {
+		t.Fatalf("routes: %d", len(routes))
+	}
+	call := routes[0].Call
+	if call.Fallback != "localhost:50053" || call.Hedge.Server != "localhost:50054" {
+		t.Fatalf("call: %+v", call)
+	}
+	target := routes[0].Split.Targets[0].Call
+	if target.Server != "localhost:50052" || target.Fallback != "" || target.Hedge == nil || target.Hedge.Delay != 10*time.Millisecond || target.Hedge.Server != "" {
+		t.Fatalf("target: %+v %+v", target, target.Hedge)
+	}
+}
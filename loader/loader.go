@@ -0,0 +1,403 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vizee/gapi-proto-go/gapi"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type MethodError struct {
+	Method string
+	Err    error
+}
+
+func (e *MethodError) Error() string {
+	return fmt.Sprintf("method %s: %v", e.Method, e.Err)
+}
+
+func (e *MethodError) Unwrap() error {
+	return e.Err
+}
+
+var kindMapping = map[protoreflect.Kind]jsonpb.Kind{
+	protoreflect.BoolKind:     jsonpb.BoolKind,
+	protoreflect.EnumKind:     jsonpb.Int32Kind,
+	protoreflect.Int32Kind:    jsonpb.Int32Kind,
+	protoreflect.Sint32Kind:   jsonpb.Sint32Kind,
+	protoreflect.Uint32Kind:   jsonpb.Uint32Kind,
+	protoreflect.Int64Kind:    jsonpb.Int64Kind,
+	protoreflect.Sint64Kind:   jsonpb.Sint64Kind,
+	protoreflect.Uint64Kind:   jsonpb.Uint64Kind,
+	protoreflect.Sfixed32Kind: jsonpb.Sfixed32Kind,
+	protoreflect.Fixed32Kind:  jsonpb.Fixed32Kind,
+	protoreflect.FloatKind:    jsonpb.FloatKind,
+	protoreflect.Sfixed64Kind: jsonpb.Sfixed64Kind,
+	protoreflect.Fixed64Kind:  jsonpb.Fixed64Kind,
+	protoreflect.DoubleKind:   jsonpb.DoubleKind,
+	protoreflect.StringKind:   jsonpb.StringKind,
+	protoreflect.BytesKind:    jsonpb.BytesKind,
+	protoreflect.MessageKind:  jsonpb.MessageKind,
+}
+
+var bindMapping = map[gapi.FIELD_BIND]metadata.BindSource{
+	gapi.FIELD_BIND_FROM_DEFAULT: metadata.BindDefault,
+	gapi.FIELD_BIND_FROM_QUERY:   metadata.BindQuery,
+	gapi.FIELD_BIND_FROM_PARAMS:  metadata.BindParams,
+	gapi.FIELD_BIND_FROM_HEADER:  metadata.BindHeader,
+	gapi.FIELD_BIND_FROM_CONTEXT: metadata.BindContext,
+}
+
+// Loader 把带有 gapi 注解的描述转换为路由，同一个 Loader 中的消息类型只会转换一次
+type Loader struct {
+	// Server 在服务没有 gapi.server 注解时使用
+	Server string
+
+	messages map[protoreflect.FullName]*jsonpb.Message
+}
+
+func NewLoader() *Loader {
+	return &Loader{
+		messages: make(map[protoreflect.FullName]*jsonpb.Message),
+	}
+}
+
+func fieldName(fd protoreflect.FieldDescriptor) string {
+	alias, _ := proto.GetExtension(fd.Options(), gapi.E_Alias).(string)
+	if alias != "" {
+		return alias
+	}
+	return string(fd.Name())
+}
+
+func (l *Loader) convertField(fd protoreflect.FieldDescriptor, added *[]protoreflect.FullName) (jsonpb.Field, error) {
+	opts := fd.Options()
+	if raw, _ := proto.GetExtension(opts, gapi.E_RawData).(bool); raw {
+		return jsonpb.Field{}, fmt.Errorf("field %s: raw_data is not supported", fd.FullName())
+	}
+	if embed, _ := proto.GetExtension(opts, gapi.E_Embed).(bool); embed {
+		return jsonpb.Field{}, fmt.Errorf("field %s: embed is not supported", fd.FullName())
+	}
+
+	field := jsonpb.Field{
+		Name:     fieldName(fd),
+		Tag:      uint32(fd.Number()),
+		Repeated: fd.IsList(),
+	}
+	if fd.IsMap() {
+		entry, err := l.message(fd.Message(), added)
+		if err != nil {
+			return jsonpb.Field{}, err
+		}
+		field.Kind = jsonpb.MapKind
+		field.Ref = entry
+	} else {
+		kind, ok := kindMapping[fd.Kind()]
+		if !ok {
+			return jsonpb.Field{}, fmt.Errorf("field %s: unsupported kind %v", fd.FullName(), fd.Kind())
+		}
+		field.Kind = kind
+		if kind == jsonpb.MessageKind {
+			ref, err := l.message(fd.Message(), added)
+			if err != nil {
+				return jsonpb.Field{}, err
+			}
+			field.Ref = ref
+		}
+	}
+	if bind, _ := proto.GetExtension(opts, gapi.E_Bind).(gapi.FIELD_BIND); bind != gapi.FIELD_BIND_FROM_DEFAULT {
+		// 绑定的字段不能从 JSON 读取，也不会输出到 JSON
+		field.Omit = jsonpb.OmitAlways
+	} else if omitEmpty, _ := proto.GetExtension(opts, gapi.E_OmitEmpty).(bool); omitEmpty {
+		field.Omit = jsonpb.OmitEmpty
+	}
+	return field, nil
+}
+
+// Message 把消息描述转换为 jsonpb.Message，支持递归引用的消息
+func (l *Loader) Message(md protoreflect.MessageDescriptor) (*jsonpb.Message, error) {
+	var added []protoreflect.FullName
+	msg, err := l.message(md, &added)
+	if err != nil {
+		// 本次转换中缓存的消息可能引用了没有完成转换的消息，全部丢弃
+		for _, name := range added {
+			delete(l.messages, name)
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (l *Loader) message(md protoreflect.MessageDescriptor, added *[]protoreflect.FullName) (*jsonpb.Message, error) {
+	msg := l.messages[md.FullName()]
+	if msg != nil {
+		return msg, nil
+	}
+	msg = &jsonpb.Message{
+		Name: "." + string(md.FullName()),
+	}
+	l.messages[md.FullName()] = msg
+	*added = append(*added, md.FullName())
+
+	fds := md.Fields()
+	fields := make([]jsonpb.Field, 0, fds.Len())
+	for i := 0; i < fds.Len(); i++ {
+		field, err := l.convertField(fds.Get(i), added)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	msg.Fields = fields
+	msg.BakeTagIndex()
+	msg.BakeNameIndex()
+	return msg, nil
+}
+
+// bindingKind 返回字段绑定时使用的类型，与 jsonapi 支持的绑定类型保持一致
+func bindingKind(fd protoreflect.FieldDescriptor) (jsonpb.Kind, error) {
+	if fd.IsList() || fd.IsMap() {
+		return 0, fmt.Errorf("field %s: repeated field can not be bound", fd.FullName())
+	}
+	switch fd.Kind() {
+	case protoreflect.Int64Kind:
+		return jsonpb.Int64Kind, nil
+	case protoreflect.Int32Kind, protoreflect.EnumKind:
+		return jsonpb.Int32Kind, nil
+	case protoreflect.StringKind:
+		return jsonpb.StringKind, nil
+	case protoreflect.BoolKind:
+		return jsonpb.BoolKind, nil
+	}
+	return 0, fmt.Errorf("field %s: kind %v can not be bound", fd.FullName(), fd.Kind())
+}
+
+func fieldBindings(md protoreflect.MessageDescriptor) ([]metadata.FieldBinding, error) {
+	var bindings []metadata.FieldBinding
+	fds := md.Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		bind, _ := proto.GetExtension(fd.Options(), gapi.E_Bind).(gapi.FIELD_BIND)
+		if bind == gapi.FIELD_BIND_FROM_DEFAULT {
+			continue
+		}
+		source, ok := bindMapping[bind]
+		if !ok {
+			return nil, fmt.Errorf("field %s: unknown bind %v", fd.FullName(), bind)
+		}
+		kind, err := bindingKind(fd)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, metadata.FieldBinding{
+			Name: fieldName(fd),
+			Kind: kind,
+			Tag:  uint32(fd.Number()),
+			Bind: source,
+		})
+	}
+	return bindings, nil
+}
+
+func httpPattern(h *gapi.Http) (string, string, error) {
+	switch p := h.Pattern.(type) {
+	case *gapi.Http_Get:
+		return "GET", p.Get, nil
+	case *gapi.Http_Post:
+		return "POST", p.Post, nil
+	case *gapi.Http_Put:
+		return "PUT", p.Put, nil
+	case *gapi.Http_Delete:
+		return "DELETE", p.Delete, nil
+	case *gapi.Http_Patch:
+		return "PATCH", p.Patch, nil
+	case *gapi.Http_Custom:
+		if p.Custom.GetMethod() == "" {
+			return "", "", errors.New("custom pattern without method")
+		}
+		return p.Custom.GetMethod(), p.Custom.GetPath(), nil
+	}
+	return "", "", errors.New("http pattern not specified")
+}
+
+func streamType(md protoreflect.MethodDescriptor) metadata.StreamType {
+	switch {
+	case md.IsStreamingClient() && md.IsStreamingServer():
+		return metadata.BidiStream
+	case md.IsStreamingServer():
+		return metadata.ServerStream
+	case md.IsStreamingClient():
+		return metadata.ClientStream
+	}
+	return metadata.NoStream
+}
+
+func (l *Loader) methodRoute(sd protoreflect.ServiceDescriptor, md protoreflect.MethodDescriptor, h *gapi.Http) (*metadata.Route, error) {
+	sopts := sd.Options()
+	server, _ := proto.GetExtension(sopts, gapi.E_Server).(string)
+	if server == "" {
+		server = l.Server
+		if server == "" {
+			return nil, fmt.Errorf("service %s: server not specified", sd.FullName())
+		}
+	}
+
+	method, path, err := httpPattern(h)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := proto.GetExtension(sopts, gapi.E_PathPrefix).(string)
+	path = prefix + path
+	if path == "" || path[0] != '/' {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	handler := h.GetHandler()
+	if handler == "" {
+		handler, _ = proto.GetExtension(sopts, gapi.E_DefaultHandler).(string)
+		if handler == "" {
+			return nil, errors.New("handler not specified")
+		}
+	}
+	timeout := h.GetTimeout()
+	if timeout == 0 {
+		timeout, _ = proto.GetExtension(sopts, gapi.E_DefaultTimeout).(int64)
+	}
+	if timeout < 0 {
+		return nil, fmt.Errorf("invalid timeout %d", timeout)
+	}
+
+	suse, _ := proto.GetExtension(sopts, gapi.E_Use).([]string)
+	var use []string
+	if len(suse)+len(h.GetUse()) > 0 {
+		use = append(append(make([]string, 0, len(suse)+len(h.GetUse())), suse...), h.GetUse()...)
+	}
+
+	in, err := l.Message(md.Input())
+	if err != nil {
+		return nil, err
+	}
+	out, err := l.Message(md.Output())
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := fieldBindings(md.Input())
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadata.Route{
+		Method: method,
+		Path:   path,
+		Use:    use,
+		Call: &metadata.Call{
+			Server:   server,
+			Handler:  handler,
+			Method:   "/" + string(sd.FullName()) + "/" + string(md.Name()),
+			Stream:   streamType(md),
+			In:       in,
+			Out:      out,
+			Bindings: bindings,
+			Timeout:  time.Duration(timeout) * time.Millisecond,
+			// 只有声明了幂等的方法才能重试或者 hedging
+			Idempotent: idempotent(md),
+		},
+	}, nil
+}
+
+// idempotent 按 MethodOptions.idempotency_level 判断方法是否可以安全地重复调用
+func idempotent(md protoreflect.MethodDescriptor) bool {
+	opts, _ := md.Options().(*descriptorpb.MethodOptions)
+	switch opts.GetIdempotencyLevel() {
+	case descriptorpb.MethodOptions_IDEMPOTENT, descriptorpb.MethodOptions_NO_SIDE_EFFECTS:
+		return true
+	}
+	return false
+}
+
+// ServiceRoutes 返回服务中所有带有 gapi.http 注解的方法的路由，
+// 出错的方法会被跳过，错误以 MethodError 的形式合并返回
+func (l *Loader) ServiceRoutes(sd protoreflect.ServiceDescriptor) ([]*metadata.Route, error) {
+	var (
+		routes []*metadata.Route
+		errs   []error
+	)
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		md := methods.Get(i)
+		h, _ := proto.GetExtension(md.Options(), gapi.E_Http).(*gapi.Http)
+		if h == nil {
+			continue
+		}
+		route, err := l.methodRoute(sd, md, h)
+		if err != nil {
+			errs = append(errs, &MethodError{Method: string(md.FullName()), Err: err})
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes, errors.Join(errs...)
+}
+
+func (l *Loader) FileRoutes(fd protoreflect.FileDescriptor) ([]*metadata.Route, error) {
+	var (
+		routes []*metadata.Route
+		errs   []error
+	)
+	services := fd.Services()
+	for i := 0; i < services.Len(); i++ {
+		rs, err := l.ServiceRoutes(services.Get(i))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		routes = append(routes, rs...)
+	}
+	return routes, errors.Join(errs...)
+}
+
+func (l *Loader) FilesRoutes(files *protoregistry.Files) ([]*metadata.Route, error) {
+	var (
+		routes []*metadata.Route
+		errs   []error
+	)
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		rs, err := l.FileRoutes(fd)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		routes = append(routes, rs...)
+		return true
+	})
+	return routes, errors.Join(errs...)
+}
+
+func LoadFileDescriptorSet(fds *descriptorpb.FileDescriptorSet) ([]*metadata.Route, error) {
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+	return NewLoader().FilesRoutes(files)
+}
+
+// LoadFile 读取 protoc --descriptor_set_out 输出的文件，需要包含 gapi/annotation.proto 等依赖
+func LoadFile(name string) ([]*metadata.Route, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var fds descriptorpb.FileDescriptorSet
+	err = proto.Unmarshal(data, &fds)
+	if err != nil {
+		return nil, err
+	}
+	return LoadFileDescriptorSet(&fds)
+}
@@ -0,0 +1,194 @@
+package loader
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi-proto-go/gapi"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func mockField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func mockMethod(name string, input string, output string, h *gapi.Http) *descriptorpb.MethodDescriptorProto {
+	opts := &descriptorpb.MethodOptions{}
+	if h != nil {
+		proto.SetExtension(opts, gapi.E_Http, h)
+	}
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(input),
+		OutputType: proto.String(output),
+		Options:    opts,
+	}
+}
+
+func mockFileDescriptorSet() *descriptorpb.FileDescriptorSet {
+	uid := mockField("uid", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64, "")
+	uid.Options = &descriptorpb.FieldOptions{}
+	proto.SetExtension(uid.Options, gapi.E_Bind, gapi.FIELD_BIND_FROM_CONTEXT)
+	sum := mockField("sum", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, "")
+	sum.Options = &descriptorpb.FieldOptions{}
+	proto.SetExtension(sum.Options, gapi.E_Alias, "total")
+	proto.SetExtension(sum.Options, gapi.E_OmitEmpty, true)
+	children := mockField("children", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".pdtest.AddResponse")
+	children.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	sopts := &descriptorpb.ServiceOptions{}
+	proto.SetExtension(sopts, gapi.E_Server, "localhost:50051")
+	proto.SetExtension(sopts, gapi.E_DefaultHandler, "jsonapi")
+	proto.SetExtension(sopts, gapi.E_DefaultTimeout, int64(3000))
+	proto.SetExtension(sopts, gapi.E_PathPrefix, "/calc")
+	proto.SetExtension(sopts, gapi.E_Use, []string{"auth"})
+
+	add := mockMethod("Add", ".pdtest.AddRequest", ".pdtest.AddResponse", &gapi.Http{
+		Pattern: &gapi.Http_Post{Post: "/add"},
+		Use:     []string{"log"},
+		Timeout: 500,
+	})
+	add.Options.IdempotencyLevel = descriptorpb.MethodOptions_NO_SIDE_EFFECTS.Enum()
+
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(gapi.File_gapi_annotation_proto),
+			{
+				Name:       proto.String("pdtest.proto"),
+				Package:    proto.String("pdtest"),
+				Syntax:     proto.String("proto3"),
+				Dependency: []string{"gapi/annotation.proto"},
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("AddRequest"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							mockField("a", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+							mockField("b", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+							uid,
+						},
+					},
+					{
+						Name:  proto.String("AddResponse"),
+						Field: []*descriptorpb.FieldDescriptorProto{sum, children},
+					},
+				},
+				Service: []*descriptorpb.ServiceDescriptorProto{
+					{
+						Name:    proto.String("Calc"),
+						Options: sopts,
+						Method: []*descriptorpb.MethodDescriptorProto{
+							add,
+							mockMethod("Sub", ".pdtest.AddRequest", ".pdtest.AddResponse", &gapi.Http{
+								Pattern: &gapi.Http_Custom{Custom: &gapi.CustomPattern{Path: "/sub"}},
+							}),
+							mockMethod("Internal", ".pdtest.AddRequest", ".pdtest.AddResponse", nil),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLoadFileDescriptorSet(t *testing.T) {
+	routes, err := LoadFileDescriptorSet(mockFileDescriptorSet())
+	var me *MethodError
+	if !errors.As(err, &me) || me.Method != "pdtest.Calc.Sub" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(routes) != 1 {
+		t.Fatalf("routes: %d", len(routes))
+	}
+
+	route := routes[0]
+	if route.Method != "POST" || route.Path != "/calc/add" || len(route.Use) != 2 || route.Use[0] != "auth" || route.Use[1] != "log" {
+		t.Fatalf("route: %+v", route)
+	}
+	call := route.Call
+	if call.Server != "localhost:50051" || call.Handler != "jsonapi" || call.Method != "/pdtest.Calc/Add" || call.Timeout != 500*time.Millisecond || !call.Idempotent {
+		t.Fatalf("call: %+v", call)
+	}
+	if len(call.Bindings) != 1 || call.Bindings[0] != (metadata.FieldBinding{Name: "uid", Kind: jsonpb.Int64Kind, Tag: 3, Bind: metadata.BindContext}) {
+		t.Fatalf("bindings: %+v", call.Bindings)
+	}
+	if f := call.In.FieldByName("uid"); f == nil || f.Omit != jsonpb.OmitAlways {
+		t.Fatalf("uid: %+v", f)
+	}
+	if f := call.Out.FieldByName("total"); f == nil || f.Tag != 1 || f.Omit != jsonpb.OmitEmpty {
+		t.Fatalf("total: %+v", f)
+	}
+	if f := call.Out.FieldByTag(2); f == nil || !f.Repeated || f.Ref != call.Out {
+		t.Fatalf("children: %+v", f)
+	}
+}
+
+func TestLoader_MessageError(t *testing.T) {
+	bad := mockField("bad", 2, descriptorpb.FieldDescriptorProto_TYPE_BYTES, "")
+	bad.Options = &descriptorpb.FieldOptions{}
+	proto.SetExtension(bad.Options, gapi.E_RawData, true)
+	files, err := protodesc.NewFiles(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(gapi.File_gapi_annotation_proto),
+			{
+				Name:       proto.String("bad.proto"),
+				Package:    proto.String("badtest"),
+				Syntax:     proto.String("proto3"),
+				Dependency: []string{"gapi/annotation.proto"},
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("Outer"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							mockField("inner", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".badtest.Inner"),
+							bad,
+						},
+					},
+					{
+						Name: proto.String("Inner"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							mockField("outer", 1, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".badtest.Outer"),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(name string) protoreflect.MessageDescriptor {
+		d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.(protoreflect.MessageDescriptor)
+	}
+
+	l := NewLoader()
+	if _, err := l.Message(find("badtest.Outer")); err == nil {
+		t.Fatal("Outer should fail")
+	}
+	// Inner 引用了转换失败的 Outer，同样不能转换
+	if msg, err := l.Message(find("badtest.Inner")); err == nil {
+		t.Fatalf("Inner should fail: %+v", msg)
+	}
+	if len(l.messages) != 0 {
+		t.Fatalf("cached messages: %v", l.messages)
+	}
+}
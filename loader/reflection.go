@@ -0,0 +1,204 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type reflectClient struct {
+	stream rpb.ServerReflection_ServerReflectionInfoClient
+	files  map[string]*descriptorpb.FileDescriptorProto
+}
+
+func (c *reflectClient) request(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	err := c.stream.Send(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if e := resp.GetErrorResponse(); e != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", e.ErrorCode, e.ErrorMessage)
+	}
+	return resp, nil
+}
+
+func (c *reflectClient) listServices() ([]string, error) {
+	resp, err := c.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	services := make([]string, 0, len(resp.GetListServicesResponse().GetService()))
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.Name)
+	}
+	return services, nil
+}
+
+func (c *reflectClient) addFiles(resp *rpb.ServerReflectionResponse) error {
+	for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		err := proto.Unmarshal(data, fd)
+		if err != nil {
+			return err
+		}
+		c.files[fd.GetName()] = fd
+	}
+	return nil
+}
+
+func (c *reflectClient) fetchSymbol(symbol string) error {
+	resp, err := c.request(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	if err != nil {
+		return err
+	}
+	return c.addFiles(resp)
+}
+
+// fetchDependencies 补全服务端没有返回的依赖
+func (c *reflectClient) fetchDependencies() error {
+	for {
+		var missing string
+		for _, fd := range c.files {
+			for _, dep := range fd.GetDependency() {
+				if c.files[dep] == nil {
+					missing = dep
+					break
+				}
+			}
+			if missing != "" {
+				break
+			}
+		}
+		if missing == "" {
+			return nil
+		}
+		resp, err := c.request(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: missing},
+		})
+		if err != nil {
+			return err
+		}
+		err = c.addFiles(resp)
+		if err != nil {
+			return err
+		}
+		if c.files[missing] == nil {
+			return fmt.Errorf("file %s not found", missing)
+		}
+	}
+}
+
+func isReflectionService(name string) bool {
+	return name == "grpc.reflection.v1alpha.ServerReflection" || name == "grpc.reflection.v1.ServerReflection"
+}
+
+// ReflectRoutes 通过 cc 上的服务端反射获取描述，并生成该服务端所有服务的路由，
+// 没有 gapi.server 注解的服务使用 server 作为地址
+func ReflectRoutes(ctx context.Context, cc *grpc.ClientConn, server string) ([]*metadata.Route, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(cc).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &reflectClient{
+		stream: stream,
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+	services, err := c.listServices()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range services {
+		if isReflectionService(name) {
+			continue
+		}
+		err = c.fetchSymbol(name)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		names = append(names, name)
+	}
+	err = c.fetchDependencies()
+	if err != nil {
+		return nil, err
+	}
+	stream.CloseSend()
+
+	fds := &descriptorpb.FileDescriptorSet{
+		File: make([]*descriptorpb.FileDescriptorProto, 0, len(c.files)),
+	}
+	for _, fd := range c.files {
+		fds.File = append(fds.File, fd)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, err
+	}
+
+	l := NewLoader()
+	l.Server = server
+	var (
+		routes []*metadata.Route
+		errs   []error
+	)
+	for _, name := range names {
+		desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", name, err))
+			continue
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is not a service", name))
+			continue
+		}
+		rs, err := l.ServiceRoutes(sd)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		routes = append(routes, rs...)
+	}
+	return routes, errors.Join(errs...)
+}
+
+// ReflectServers 使用 dialer 依次连接 servers 并合并所有路由，连接在返回前关闭。
+// 出错的服务端会被跳过，错误合并返回
+func ReflectServers(ctx context.Context, dialer engine.Dialer, servers []string) ([]*metadata.Route, error) {
+	var (
+		routes []*metadata.Route
+		errs   []error
+	)
+	for _, server := range servers {
+		cc, err := dialer.Dial(server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("dial %s: %w", server, err))
+			continue
+		}
+		rs, err := ReflectRoutes(ctx, cc, server)
+		cc.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reflect %s: %w", server, err))
+		}
+		routes = append(routes, rs...)
+	}
+	return routes, errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package loader
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/vizee/gapi-proto-go/gapi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+type mockServiceInfo map[string]grpc.ServiceInfo
+
+func (m mockServiceInfo) GetServiceInfo() map[string]grpc.ServiceInfo {
+	return m
+}
+
+func TestReflectRoutes(t *testing.T) {
+	fds := mockFileDescriptorSet()
+	proto.ClearExtension(fds.File[2].Service[0].Options, gapi.E_Server)
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	rpb.RegisterServerReflectionServer(srv, reflection.NewServer(reflection.ServerOptions{
+		Services: mockServiceInfo{
+			"pdtest.Calc": {},
+			"grpc.reflection.v1alpha.ServerReflection": {},
+		},
+		DescriptorResolver: files,
+	}))
+	go srv.Serve(lis)
+	defer srv.Stop()
+	cc, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+
+	routes, err := ReflectRoutes(context.Background(), cc, "calc:50051")
+	if err == nil {
+		t.Fatal("expect error of pdtest.Calc.Sub")
+	}
+	if len(routes) != 1 || routes[0].Path != "/calc/add" || routes[0].Call.Server != "calc:50051" || routes[0].Call.Method != "/pdtest.Calc/Add" {
+		t.Fatalf("routes: %+v", routes)
+	}
+}
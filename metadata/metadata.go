@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/vizee/jsonpb"
+	"google.golang.org/grpc/codes"
 )
 
 type BindSource uint32
@@ -23,14 +24,73 @@ type FieldBinding struct {
 	Bind BindSource
 }
 
+type StreamType uint32
+
+const (
+	NoStream StreamType = iota
+	ServerStream
+	ClientStream
+	BidiStream
+)
+
+// ForwardRule 描述如何把请求头部或 Context 值转发为 GRPC metadata。
+// Prefix 为 true 时 Name 作为前缀匹配，Rename 替换匹配到的前缀；否则 Rename 替换整个名字。Rename 为空时都保留原来的名字
+type ForwardRule struct {
+	Name   string
+	Prefix bool
+	Rename string
+	Source BindSource // 仅支持 BindHeader 和 BindContext
+}
+
+// RetryPolicy 描述一元调用失败后的重试，仅对 Idempotent 的 Call 生效
+type RetryPolicy struct {
+	MaxAttempts       int          // 包括第一次调用
+	Codes             []codes.Code // 可重试的状态码，默认只有 Unavailable
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration // 0 表示不限制
+	BackoffMultiplier float64       // 默认 2
+	PerTryTimeout     time.Duration // 每次调用的超时，整体仍然受 Timeout 限制
+}
+
+// HedgePolicy 在调用超过 Delay 没有返回时发出额外的调用并采用最先成功的结果，仅对 Idempotent 的 Call 生效，优先于 Retry
+type HedgePolicy struct {
+	Delay       time.Duration
+	MaxAttempts int    // 包括第一次调用，默认 2
+	Server      string // 额外的调用发往的 server，为空时使用 Call.Server
+}
+
 type Call struct {
 	Server   string
+	Fallback string // Server 健康检查失败时改用的 server
 	Handler  string
 	Method   string
+	Stream   StreamType
 	In       *jsonpb.Message
 	Out      *jsonpb.Message
 	Bindings []FieldBinding // 仅支持从参数提取 Bindings
-	Timeout  time.Duration
+	Forward  []ForwardRule
+	Returns  []string      // 需要写回 HTTP 响应的 GRPC header 和 trailer，以 * 结尾时按前缀匹配
+	Timeout  time.Duration // 也是客户端通过 grpc-timeout 或 X-Request-Timeout 指定超时的上限
+	// Idempotent 标记方法可以安全地重复调用
+	Idempotent bool
+	Retry      *RetryPolicy
+	Hedge      *HedgePolicy
+}
+
+// SplitTarget 是路由的备选调用目标
+type SplitTarget struct {
+	Call   *Call
+	Weight uint32
+	Pin    []string // Split.Header 或 Split.Cookie 的值等于其中之一时固定选择此目标
+}
+
+// Split 在 Route.Call 和 Targets 之间按权重分配请求，Weight 是 Route.Call 的权重
+type Split struct {
+	Weight  uint32
+	Pin     []string // Header 或 Cookie 的值等于其中之一时固定选择 Route.Call
+	Header  string
+	Cookie  string
+	Targets []SplitTarget
 }
 
 type Route struct {
@@ -38,4 +98,5 @@ type Route struct {
 	Path   string
 	Use    []string
 	Call   *Call
+	Split  *Split
 }
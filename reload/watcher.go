@@ -0,0 +1,313 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/log"
+	"github.com/vizee/gapi/metadata"
+)
+
+const defaultDebounce = 500 * time.Millisecond
+
+type LoadFunc func() ([]*metadata.Route, error)
+
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func routeKey(r *metadata.Route) string {
+	return r.Method + " " + r.Path
+}
+
+// sameRoute 比较路由的所有字段，reflect.DeepEqual 可以处理递归引用的消息
+func sameRoute(a, b *metadata.Route) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func DiffRoutes(old []*metadata.Route, routes []*metadata.Route) *Diff {
+	prev := make(map[string]*metadata.Route, len(old))
+	for _, r := range old {
+		prev[routeKey(r)] = r
+	}
+	diff := &Diff{}
+	for _, r := range routes {
+		key := routeKey(r)
+		o := prev[key]
+		if o == nil {
+			diff.Added = append(diff.Added, key)
+			continue
+		}
+		delete(prev, key)
+		if !sameRoute(o, r) {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range prev {
+		diff.Removed = append(diff.Removed, key)
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// Watcher 在文件变化后重新加载路由并重建 engine 的 router，加载或重建失败时保留原来的 router
+type Watcher struct {
+	// Debounce 是最后一次变化到重新加载之间的等待时间
+	Debounce time.Duration
+	// Poll 大于 0 时使用轮询检查文件的修改时间和大小，否则使用 fsnotify
+	Poll time.Duration
+	// OnReload 在每次重新加载后调用，失败时 diff 为 nil
+	OnReload func(diff *Diff, err error)
+
+	engine *engine.Engine
+	load   LoadFunc
+	files  []string
+
+	mu     sync.Mutex
+	routes []*metadata.Route
+}
+
+func NewWatcher(e *engine.Engine, load LoadFunc, files ...string) *Watcher {
+	cleaned := make([]string, 0, len(files))
+	for _, name := range files {
+		cleaned = append(cleaned, filepath.Clean(name))
+	}
+	return &Watcher{
+		Debounce: defaultDebounce,
+		engine:   e,
+		load:     load,
+		files:    cleaned,
+	}
+}
+
+func (w *Watcher) Routes() []*metadata.Route {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.routes
+}
+
+// Reload 立即加载、校验并应用新的路由，加载或校验有任何错误都不会替换当前的 router
+func (w *Watcher) Reload() (*Diff, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	routes, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	err = w.engine.Validate(routes).Err()
+	if err != nil {
+		return nil, err
+	}
+	err = w.engine.RebuildRouter(routes, false)
+	if err != nil {
+		return nil, err
+	}
+	diff := DiffRoutes(w.routes, routes)
+	w.routes = routes
+	return diff, nil
+}
+
+func (w *Watcher) reload() {
+	diff, err := w.Reload()
+	if err != nil {
+		log.Errorf("Reload routes: %v", err)
+	} else if diff.Empty() {
+		log.Debugf("Routes reloaded without changes")
+	} else {
+		log.Warnf("Routes reloaded: %d added %v, %d removed %v, %d changed %v",
+			len(diff.Added), diff.Added, len(diff.Removed), diff.Removed, len(diff.Changed), diff.Changed)
+	}
+	if w.OnReload != nil {
+		w.OnReload(diff, err)
+	}
+}
+
+func (w *Watcher) watched(name string) bool {
+	name = filepath.Clean(name)
+	for _, f := range w.files {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveFiles 返回文件经过符号链接解析后的路径，解析失败的文件不包含在结果中
+func resolveFiles(files []string) map[string]string {
+	targets := make(map[string]string, len(files))
+	for _, name := range files {
+		target, err := filepath.EvalSymlinks(name)
+		if err != nil {
+			continue
+		}
+		targets[name] = target
+	}
+	return targets
+}
+
+// Run 监视文件变化直到 ctx 结束，首次加载需要调用方通过 Reload 完成
+func (w *Watcher) Run(ctx context.Context) error {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	if w.Poll > 0 {
+		go w.poll(ctx, notify)
+	} else {
+		fw, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		defer fw.Close()
+		// 监视所在目录，编辑器通常通过替换文件保存。
+		// 文件是符号链接时同时监视链接目标所在的目录，Kubernetes 的 ConfigMap 通过替换 ..data 链接更新，
+		// 文件本身没有事件，需要比较解析后的路径
+		dirs := make(map[string]bool)
+		addDir := func(dir string) error {
+			if dirs[dir] {
+				return nil
+			}
+			err := fw.Add(dir)
+			if err == nil {
+				dirs[dir] = true
+			}
+			return err
+		}
+		for _, name := range w.files {
+			err = addDir(filepath.Dir(name))
+			if err != nil {
+				return err
+			}
+		}
+		targets := resolveFiles(w.files)
+		for _, target := range targets {
+			err = addDir(filepath.Dir(target))
+			if err != nil {
+				return err
+			}
+		}
+		go func() {
+			for {
+				select {
+				case ev, ok := <-fw.Events:
+					if !ok {
+						return
+					}
+					name := filepath.Clean(ev.Name)
+					resolved := resolveFiles(w.files)
+					changed := w.watched(name) || len(resolved) != len(targets)
+					for f, target := range resolved {
+						if target == name {
+							changed = true
+						} else if targets[f] != target {
+							changed = true
+							err := addDir(filepath.Dir(target))
+							if err != nil {
+								log.Warnf("Watch routes: %v", err)
+							}
+						}
+					}
+					targets = resolved
+					if changed {
+						notify()
+					}
+				case err, ok := <-fw.Errors:
+					if !ok {
+						return
+					}
+					log.Warnf("Watch routes: %v", err)
+				}
+			}
+		}()
+	}
+
+	debounce := w.Debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+	var (
+		timer *time.Timer
+		fire  <-chan time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return ctx.Err()
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(debounce)
+			fire = timer.C
+		case <-fire:
+			timer, fire = nil, nil
+			w.reload()
+		}
+	}
+}
+
+type fileState struct {
+	target  string
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+	for name, target := range resolveFiles(files) {
+		fi, err := os.Stat(target)
+		if err != nil {
+			continue
+		}
+		states[name] = fileState{target: target, modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return states
+}
+
+func (w *Watcher) poll(ctx context.Context, notify func()) {
+	ticker := time.NewTicker(w.Poll)
+	defer ticker.Stop()
+	last := statFiles(w.files)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			states := statFiles(w.files)
+			if len(states) != len(last) {
+				notify()
+			} else {
+				for name, st := range states {
+					if last[name] != st {
+						notify()
+						break
+					}
+				}
+			}
+			last = states
+		}
+	}
+}
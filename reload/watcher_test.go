@@ -0,0 +1,227 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vizee/gapi/engine"
+	"github.com/vizee/gapi/metadata"
+	"github.com/vizee/jsonpb"
+)
+
+type mockHandler struct{}
+
+func (*mockHandler) ReadRequest(_ *metadata.Call, ctx *engine.Context) ([]byte, error) {
+	return ctx.ReadBody()
+}
+
+func (*mockHandler) WriteResponse(_ *metadata.Call, ctx *engine.Context, data []byte) error {
+	_, err := ctx.Response().Write(data)
+	return err
+}
+
+// loadPaths 把文件中的每一行作为一个 GET 路由
+func loadPaths(name string) LoadFunc {
+	return func() ([]*metadata.Route, error) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var routes []*metadata.Route
+		for _, path := range strings.Fields(string(data)) {
+			routes = append(routes, &metadata.Route{
+				Method: "GET",
+				Path:   path,
+				Call:   &metadata.Call{Server: "localhost:50051", Handler: "mock", Method: "/mock.Service/Get"},
+			})
+		}
+		return routes, nil
+	}
+}
+
+func TestDiffRoutes(t *testing.T) {
+	route := func(path string, server string) *metadata.Route {
+		return &metadata.Route{Method: "GET", Path: path, Call: &metadata.Call{Server: server}}
+	}
+	diff := DiffRoutes(
+		[]*metadata.Route{route("/a", "s1"), route("/b", "s1"), route("/c", "s1")},
+		[]*metadata.Route{route("/a", "s1"), route("/b", "s2"), route("/d", "s1")},
+	)
+	want := &Diff{Added: []string{"GET /d"}, Removed: []string{"GET /c"}, Changed: []string{"GET /b"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Fatalf("DiffRoutes() = %+v, want %+v", diff, want)
+	}
+}
+
+func TestDiffRoutes_CallDetails(t *testing.T) {
+	message := func(name string, kind jsonpb.Kind) *jsonpb.Message {
+		msg := &jsonpb.Message{Name: name, Fields: []jsonpb.Field{{Name: "a", Kind: kind, Tag: 1}}}
+		// 递归引用自身
+		msg.Fields = append(msg.Fields, jsonpb.Field{Name: "self", Kind: jsonpb.MessageKind, Ref: msg, Tag: 2})
+		return msg
+	}
+	route := func(modify func(call *metadata.Call)) *metadata.Route {
+		call := &metadata.Call{
+			Server:   "s1",
+			In:       message(".pkg.Req", jsonpb.Int32Kind),
+			Out:      message(".pkg.Resp", jsonpb.StringKind),
+			Bindings: []metadata.FieldBinding{{Name: "a", Kind: jsonpb.Int32Kind, Tag: 1, Bind: metadata.BindQuery}},
+			Forward:  []metadata.ForwardRule{{Name: "X-Trace", Source: metadata.BindHeader}},
+			Returns:  []string{"x-rate-*"},
+		}
+		if modify != nil {
+			modify(call)
+		}
+		return &metadata.Route{Method: "GET", Path: "/a", Call: call}
+	}
+	tests := []struct {
+		name    string
+		modify  func(call *metadata.Call)
+		changed bool
+	}{
+		{"same", nil, false},
+		{"bindings", func(call *metadata.Call) { call.Bindings[0].Bind = metadata.BindParams }, true},
+		{"forward", func(call *metadata.Call) { call.Forward[0].Prefix = true }, true},
+		{"returns", func(call *metadata.Call) { call.Returns = nil }, true},
+		{"input name", func(call *metadata.Call) { call.In.Name = ".pkg.Other" }, true},
+		{"output field", func(call *metadata.Call) { call.Out.Fields[0].Kind = jsonpb.BytesKind }, true},
+		{"hedge", func(call *metadata.Call) { call.Hedge = &metadata.HedgePolicy{Delay: time.Millisecond} }, true},
+	}
+	for _, tt := range tests {
+		diff := DiffRoutes([]*metadata.Route{route(nil)}, []*metadata.Route{route(tt.modify)})
+		if changed := len(diff.Changed) == 1; changed != tt.changed {
+			t.Errorf("%s: changed = %v", tt.name, changed)
+		}
+	}
+}
+
+func testWatcher(t *testing.T, poll time.Duration) {
+	builder := engine.NewBuilder()
+	builder.RegisterHandler("mock", &mockHandler{})
+	e := builder.Build()
+	defer e.ClearRouter()
+
+	name := filepath.Join(t.TempDir(), "routes.txt")
+	err := os.WriteFile(name, []byte("/a /b"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWatcher(e, loadPaths(name), name)
+	w.Debounce = 10 * time.Millisecond
+	w.Poll = poll
+	reloaded := make(chan *Diff, 1)
+	w.OnReload = func(diff *Diff, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		reloaded <- diff
+	}
+	_, err = w.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	// 等待 watcher 开始工作
+	time.Sleep(50 * time.Millisecond)
+
+	err = os.WriteFile(name, []byte("/a /c /d"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case diff := <-reloaded:
+		want := &Diff{Added: []string{"GET /c", "GET /d"}, Removed: []string{"GET /b"}}
+		if !reflect.DeepEqual(diff, want) {
+			t.Fatalf("diff = %+v, want %+v", diff, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload timeout")
+	}
+	if len(w.Routes()) != 3 {
+		t.Fatalf("routes: %d", len(w.Routes()))
+	}
+}
+
+func TestWatcher_fsnotify(t *testing.T) {
+	testWatcher(t, 0)
+}
+
+func TestWatcher_poll(t *testing.T) {
+	testWatcher(t, 10*time.Millisecond)
+}
+
+// TestWatcher_symlinkSwap 模拟 Kubernetes ConfigMap 的更新方式：
+// routes.txt -> ..data/routes.txt，更新时创建新目录并原子地替换 ..data 链接
+func TestWatcher_symlinkSwap(t *testing.T) {
+	for _, poll := range []time.Duration{0, 10 * time.Millisecond} {
+		builder := engine.NewBuilder()
+		builder.RegisterHandler("mock", &mockHandler{})
+		e := builder.Build()
+
+		dir := t.TempDir()
+		writeVersion := func(version string, paths string) {
+			err := os.Mkdir(filepath.Join(dir, version), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(dir, version, "routes.txt"), []byte(paths), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Symlink(version, filepath.Join(dir, "..data_tmp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeVersion("..v1", "/a /b")
+		name := filepath.Join(dir, "routes.txt")
+		err := os.Symlink(filepath.Join("..data", "routes.txt"), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := NewWatcher(e, loadPaths(name), name)
+		w.Debounce = 10 * time.Millisecond
+		w.Poll = poll
+		reloaded := make(chan *Diff, 1)
+		w.OnReload = func(diff *Diff, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			reloaded <- diff
+		}
+		_, err = w.Reload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go w.Run(ctx)
+		time.Sleep(50 * time.Millisecond)
+
+		// 新版本的大小和修改时间可能与旧版本相同
+		writeVersion("..v2", "/a /c")
+		select {
+		case diff := <-reloaded:
+			want := &Diff{Added: []string{"GET /c"}, Removed: []string{"GET /b"}}
+			if !reflect.DeepEqual(diff, want) {
+				t.Fatalf("poll %v: diff = %+v, want %+v", poll, diff, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("poll %v: reload timeout", poll)
+		}
+		cancel()
+		e.ClearRouter()
+	}
+}